@@ -0,0 +1,151 @@
+package appserver
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+const DefaultMaxBatchFiles = 100
+const DefaultBatchKeyTemplate = "${uuid}${ext}"
+
+// FileDescriptor : a file the client is about to upload
+type FileDescriptor struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// FileError : a file rejected by the batch, Index is its position in the request
+type FileError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("file %d %q: %v", e.Index, e.Name, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError : every rejected file of the batch, no token is issued when it is returned
+type BatchError struct {
+	Errors []*FileError
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fileError := range e.Errors {
+		messages = append(messages, fileError.Error())
+	}
+	return fmt.Sprintf("%d files rejected: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// GenerateBatch : one token per file, each with its own key, the exact size and content type as conditions,
+// and the expiration of the batch
+func (t *Token) GenerateBatch(files []FileDescriptor) ([]*SignatureToken, error) {
+	maxBatchFiles := t.config.MaxBatchFiles
+	if maxBatchFiles == 0 {
+		maxBatchFiles = DefaultMaxBatchFiles
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("missing required files")
+	}
+	if len(files) > maxBatchFiles {
+		return nil, fmt.Errorf("too many files %d, up to %d", len(files), maxBatchFiles)
+	}
+
+	// all files are checked before any token is signed
+	var batchError BatchError
+	for i, file := range files {
+		err := t.config.validateFile(&file)
+		if file.Name == "" {
+			err = fmt.Errorf("missing required file name")
+		}
+		if err != nil {
+			batchError.Errors = append(batchError.Errors, &FileError{Index: i, Name: file.Name, Err: err})
+		}
+	}
+	if len(batchError.Errors) > 0 {
+		return nil, &batchError
+	}
+
+	var policy *Policy
+	if t.policy != nil {
+		policy = t.policy
+	} else {
+		policy = newPolicy(t.config)
+	}
+	keyGenerator, err := t.getKeyGenerator()
+	if err != nil {
+		return nil, err
+	}
+	if keyGenerator == nil {
+		if keyGenerator, err = NewKeyTemplate(DefaultBatchKeyTemplate); err != nil {
+			return nil, err
+		}
+	}
+	signedAt := t.now()
+
+	tokens := make([]*SignatureToken, 0, len(files))
+	for i, file := range files {
+		keyInput := KeyInput{Time: signedAt}
+		if t.keyInput != nil {
+			keyInput = *t.keyInput
+			if keyInput.Time.IsZero() {
+				keyInput.Time = signedAt
+			}
+		}
+		keyInput.Filename = file.Name
+
+		conditions := []any{[]any{PolicyConditionContentLengthRange, file.Size, file.Size}}
+		if file.ContentType != "" {
+			conditions = append(conditions, []any{PolicyConditionEq, "$content-type", file.ContentType})
+		}
+		token := t.SetPolicy(policy.withConditions(conditions...)).SetKeyGenerator(keyGenerator).SetKeyInput(&keyInput)
+		token.now = func() time.Time { return signedAt }
+
+		signatureToken, err := token.Generate()
+		if err != nil {
+			batchError.Errors = append(batchError.Errors, &FileError{Index: i, Name: file.Name, Err: err})
+			continue
+		}
+		tokens = append(tokens, signatureToken)
+	}
+	if len(batchError.Errors) > 0 {
+		return nil, &batchError
+	}
+	return tokens, nil
+}
+
+// validateFile : the size and content type limits of the config
+func (c *Config) validateFile(file *FileDescriptor) error {
+	if file.Size < 0 {
+		return fmt.Errorf("invalid file size %d", file.Size)
+	}
+	if c.MaxFileSize > 0 && file.Size > c.MaxFileSize {
+		return fmt.Errorf("file size %d exceeds %d", file.Size, c.MaxFileSize)
+	}
+	if len(c.ContentTypes) == 0 {
+		return nil
+	}
+
+	if file.ContentType == "" {
+		return fmt.Errorf("missing required file content type")
+	}
+	mediaType, _, err := mime.ParseMediaType(file.ContentType)
+	if err != nil {
+		return fmt.Errorf("invalid file content type %q", file.ContentType)
+	}
+	for _, allowed := range c.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("file content type %q not allowed", file.ContentType)
+}
@@ -0,0 +1,107 @@
+package appserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBatchToken(config *Config) *Token {
+	config.AccessKeyId = "yourAccessKeyId"
+	config.AccessKeySecret = "yourAccessKeySecret"
+	config.Host = "https://bucket-name.oss-cn-hangzhou.aliyuncs.com"
+	config.Directory = "user-dir-prefix/"
+	token := NewToken(config)
+	token.now = func() time.Time {
+		return time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	}
+	return token
+}
+
+func TestTokenGenerateBatch(t *testing.T) {
+	token := newTestBatchToken(&Config{
+		MaxFileSize:  10 * 1024 * 1024,
+		ContentTypes: []string{"image/*", "video/mp4"},
+	})
+	files := []FileDescriptor{
+		{Name: "image.jpg", Size: 2788, ContentType: "image/jpeg"},
+		{Name: "image.png", Size: 1024, ContentType: "image/png"},
+		{Name: "video.MP4", Size: 10 * 1024 * 1024, ContentType: "video/mp4"},
+	}
+
+	tokens, err := token.GenerateBatch(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != len(files) {
+		t.Fatal("tokens error", tokens)
+	}
+
+	keys := map[string]bool{}
+	for i, signatureToken := range tokens {
+		file := files[i]
+		if !strings.HasPrefix(signatureToken.Key, "user-dir-prefix/") || !strings.HasSuffix(signatureToken.Key, keyExt(file.Name)) {
+			t.Error("key error", signatureToken.Key)
+		}
+		keys[signatureToken.Key] = true
+		if signatureToken.Expire != tokens[0].Expire {
+			t.Error("shared expire error", signatureToken.Expire)
+		}
+
+		fields := map[string]string{"key": signatureToken.Key, "content-type": file.ContentType}
+		at := time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC)
+		if violations, err := EvaluatePolicy(signatureToken.Policy, fields, file.Size, at); err != nil || len(violations) != 0 {
+			t.Error("unexpected violations", violations, err)
+		}
+		if violations, _ := EvaluatePolicy(signatureToken.Policy, fields, file.Size+1, at); len(violations) != 1 {
+			t.Error("size violation error", violations)
+		}
+		fields["content-type"] = "text/html"
+		if violations, _ := EvaluatePolicy(signatureToken.Policy, fields, file.Size, at); len(violations) != 1 {
+			t.Error("content type violation error", violations)
+		}
+	}
+	if len(keys) != len(files) {
+		t.Error("keys are not unique", keys)
+	}
+}
+
+func TestTokenGenerateBatchRejected(t *testing.T) {
+	token := newTestBatchToken(&Config{
+		MaxFileSize:  1024,
+		ContentTypes: []string{"image/jpeg"},
+	})
+	tokens, err := token.GenerateBatch([]FileDescriptor{
+		{Name: "image.jpg", Size: 512, ContentType: "image/jpeg; charset=binary"},
+		{Name: "large.jpg", Size: 2048, ContentType: "image/jpeg"},
+		{Name: "page.html", Size: 512, ContentType: "text/html"},
+		{Name: "", Size: 512, ContentType: "image/jpeg"},
+		{Name: "unknown.jpg", Size: 512},
+	})
+	if tokens != nil {
+		t.Error("tokens must not be issued", tokens)
+	}
+	var batchError *BatchError
+	if !errors.As(err, &batchError) {
+		t.Fatal("batch error", err)
+	}
+	var indexes []int
+	for _, fileError := range batchError.Errors {
+		indexes = append(indexes, fileError.Index)
+	}
+	if len(indexes) != 4 || indexes[0] != 1 || indexes[1] != 2 || indexes[2] != 3 || indexes[3] != 4 {
+		t.Error("file errors error", batchError)
+	}
+	if !strings.Contains(err.Error(), `file 1 "large.jpg": file size 2048 exceeds 1024`) {
+		t.Error("batch error message", err)
+	}
+
+	token = newTestBatchToken(&Config{MaxBatchFiles: 1})
+	if _, err = token.GenerateBatch(make([]FileDescriptor, 2)); err == nil {
+		t.Error("too many files")
+	}
+	if _, err = token.GenerateBatch(nil); err == nil {
+		t.Error("missing files")
+	}
+}
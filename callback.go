@@ -1,17 +1,20 @@
 package appserver
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/md5"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
-	"errors"
 	"fmt"
-	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 // CallbackBody 结构体用于存储文件相关信息以及请求相关的一些元数据
@@ -40,6 +43,39 @@ type CallbackBody struct {
 	ReqId string `json:"reqId"`
 	// Operation发起请求的接口名称，例如PutObject、PostObject等
 	Operation string `json:"operation"`
+	// Vars是自定义变量，键名以x:开头，例如x:user_id
+	Vars map[string]string `json:"-"`
+}
+
+// UnmarshalJSON : also collects the custom "x:" vars wired in by Token.SetCallbackVars
+func (c *CallbackBody) UnmarshalJSON(data []byte) error {
+	type callbackBody CallbackBody
+	if err := json.Unmarshal(data, (*callbackBody)(c)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		if !strings.HasPrefix(k, CallbackVarPrefix) {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(v, &value); err != nil {
+			value = string(v)
+		}
+		if c.Vars == nil {
+			c.Vars = make(map[string]string)
+		}
+		c.Vars[k] = value
+	}
+	return nil
+}
+
+// Var : custom var value by "user_id" or "x:user_id"
+func (c *CallbackBody) Var(name string) string {
+	return c.Vars[CallbackVarPrefix+strings.TrimPrefix(name, CallbackVarPrefix)]
 }
 
 type ImageInfo struct {
@@ -55,97 +91,185 @@ const PubKeyUrlHeader = "X-Oss-Pub-Key-Url"
 const AuthorizationHeader = "Authorization"
 
 type AliyunOSSCallback struct {
-	req *http.Request
+	req      *http.Request
+	verifier *CallbackVerifier
 }
 
 func NewAliyunOSSCallback(req *http.Request) *AliyunOSSCallback {
 	return &AliyunOSSCallback{req: req}
 }
 
+// SetVerifier : verifier options, e.g. HTTPClient or PinnedPublicKeys, instead of NewCallbackVerifier()
+func (a *AliyunOSSCallback) SetVerifier(verifier *CallbackVerifier) *AliyunOSSCallback {
+	k := *a
+	k.verifier = verifier
+	return &k
+}
+
+// VerifySignature : VerifySignatureContext with the context of the request
 func (a *AliyunOSSCallback) VerifySignature() (*CallbackBody, error) {
-	bodyContent, err := io.ReadAll(a.req.Body)
-	if err != nil {
-		return nil, err
+	return a.VerifySignatureContext(a.req.Context())
+}
+
+// VerifySignatureContext : a thin wrapper of CallbackVerifier.VerifyContext, only POST is accepted
+func (a *AliyunOSSCallback) VerifySignatureContext(ctx context.Context) (*CallbackBody, error) {
+	verifier := a.verifier
+	if verifier == nil {
+		verifier = NewCallbackVerifier()
 	}
-	defer a.req.Body.Close()
-	byteMd5, err := GetMD5FromNewAuthString(bodyContent, a.req.URL.Path, a.req.URL.RawQuery)
+	bodyContent, err := verifier.readBody(a.req)
 	if err != nil {
 		return nil, err
 	}
+	return verifier.VerifyURLContext(ctx, a.req.URL, a.req.Header, bodyContent)
+}
 
-	publicKeyURLBase64 := a.req.Header.Get(PubKeyUrlHeader)
-	bytePublicKey, err := GetPublicKey(publicKeyURLBase64)
+// DecodeCallbackBody : decode by the Content-Type OSS sends, which is the callbackBodyType of the callback
+func DecodeCallbackBody(contentType string, bodyContent []byte) (*CallbackBody, error) {
+	mediaType, err := callbackMediaType(contentType, bodyContent)
 	if err != nil {
 		return nil, err
 	}
 
-	strAuthorizationBase64 := a.req.Header.Get(AuthorizationHeader)
-	authorization, err := GetAuthorization(strAuthorizationBase64)
+	callbackBody := new(CallbackBody)
+	switch mediaType {
+	case CallbackBodyTypeParam:
+		if err := json.Unmarshal(bodyContent, callbackBody); err != nil {
+			return nil, &kindError{kind: ErrCallbackBodyDecode, err: err}
+		}
+	case CallbackBodyTypeForm:
+		values, err := url.ParseQuery(string(bodyContent))
+		if err != nil {
+			return nil, &kindError{kind: ErrCallbackBodyDecode, err: err}
+		}
+		if err = callbackBody.decodeForm(values); err != nil {
+			return nil, &kindError{kind: ErrCallbackBodyDecode, err: err}
+		}
+	}
+	return callbackBody, nil
+}
+
+// callbackMediaType : CallbackBodyTypeParam or CallbackBodyTypeForm, sniffed from the body without a Content-Type
+func callbackMediaType(contentType string, bodyContent []byte) (string, error) {
+	if contentType == "" {
+		if trimmed := bytes.TrimSpace(bodyContent); len(trimmed) > 0 && trimmed[0] == '{' {
+			return CallbackBodyTypeParam, nil
+		}
+		return CallbackBodyTypeForm, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("%w %q: %v", ErrCallbackContentType, contentType, err)
+	}
+	if mediaType != CallbackBodyTypeParam && mediaType != CallbackBodyTypeForm {
+		return "", fmt.Errorf("%w %q", ErrCallbackContentType, contentType)
 	}
+	return mediaType, nil
+}
 
-	if err = VerifySignature(bytePublicKey, byteMd5, authorization); err != nil {
-		return nil, err
+// decodeForm : form keys are the json names of CallbackBodyFormParam, e.g. imageInfo.height
+func (c *CallbackBody) decodeForm(values url.Values) error {
+	var err error
+	c.Bucket = values.Get("bucket")
+	c.Object = values.Get("object")
+	c.Etag = values.Get("etag")
+	c.MimeType = values.Get("mimeType")
+	c.ImageInfo.Format = values.Get("imageInfo.format")
+	c.ContentMd5 = values.Get("contentMd5")
+	if vpcId := values.Get("vpcId"); vpcId != "" {
+		c.VpcId = &vpcId
 	}
+	c.ClientIp = values.Get("clientIp")
+	c.ReqId = values.Get("reqId")
+	c.Operation = values.Get("operation")
 
-	callbackBody := new(CallbackBody)
-	if err = json.Unmarshal(bodyContent, callbackBody); err != nil {
-		return nil, err
+	if c.Size, err = formInt(values, "size"); err != nil {
+		return err
 	}
-	return callbackBody, nil
+	if c.ImageInfo.Height, err = formInt(values, "imageInfo.height"); err != nil {
+		return err
+	}
+	if c.ImageInfo.Width, err = formInt(values, "imageInfo.width"); err != nil {
+		return err
+	}
+	if crc64 := values.Get("crc64"); crc64 != "" {
+		v, err := strconv.ParseUint(crc64, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid callback crc64 %q: %w", crc64, err)
+		}
+		c.Crc64 = uint(v)
+	}
+
+	for k := range values {
+		if strings.HasPrefix(k, CallbackVarPrefix) {
+			if c.Vars == nil {
+				c.Vars = make(map[string]string)
+			}
+			c.Vars[k] = values.Get(k)
+		}
+	}
+	return nil
+}
+
+// formInt : empty is zero, e.g. imageInfo of a non-image object
+func formInt(values url.Values, key string) (int, error) {
+	v := values.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid callback %s %q: %w", key, v, err)
+	}
+	return i, nil
 }
 
 func VerifySignature(bytePublicKey []byte, byteMd5 []byte, authorization []byte) error {
+	pub, err := ParsePublicKey(bytePublicKey)
+	if err != nil {
+		return err
+	}
+	return verifySignature(pub, byteMd5, authorization)
+}
+
+// ParsePublicKey : the rsa public key of a PKIX PEM block, errors.Is(err, ErrInvalidPEM) or ErrNotRSAPublicKey
+func ParsePublicKey(bytePublicKey []byte) (*rsa.PublicKey, error) {
 	pubBlock, _ := pem.Decode(bytePublicKey)
 	if pubBlock == nil {
-		// fmt.Printf("Failed to parse PEM block containing the public key")
-		return errors.New("failed to parse PEM block containing the public key")
+		return nil, fmt.Errorf("%w: no PEM block containing the public key", ErrInvalidPEM)
 	}
 	pubInterface, err := x509.ParsePKIXPublicKey(pubBlock.Bytes)
-	if (pubInterface == nil) || (err != nil) {
-		//fmt.Printf("x509.ParsePKIXPublicKey(publicKey) failed : %s \n", err.Error())
-		return fmt.Errorf("x509.ParsePKIXPublicKey(publicKey) failed : %w \n", err)
+	if err != nil {
+		return nil, &kindError{kind: ErrInvalidPEM, err: err}
 	}
-	pub := pubInterface.(*rsa.PublicKey)
-
-	errorVerifyPKCS1v15 := rsa.VerifyPKCS1v15(pub, crypto.MD5, byteMd5, authorization)
-	if errorVerifyPKCS1v15 != nil {
-		//fmt.Printf("\nSignature Verification is Failed : %s \n", errorVerifyPKCS1v15.Error())
-		//printByteArray(byteMd5, "AuthMd5(fromNewAuthString)")
-		//printByteArray(bytePublicKey, "PublicKeyBase64")
-		//printByteArray(authorization, "AuthorizationFromRequest")
-		return fmt.Errorf("Signature Verification is Failed : %v \n", errorVerifyPKCS1v15.Error())
+	pub, ok := pubInterface.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotRSAPublicKey, pubInterface)
 	}
+	return pub, nil
+}
 
-	// fmt.Printf("\nSignature Verification is Successful. \n")
+// verifySignature : errors.Is(err, ErrSignatureMismatch), and rsa.ErrVerification
+func verifySignature(pub *rsa.PublicKey, byteMd5 []byte, authorization []byte) error {
+	if err := rsa.VerifyPKCS1v15(pub, crypto.MD5, byteMd5, authorization); err != nil {
+		return &kindError{kind: ErrSignatureMismatch, err: err}
+	}
 	return nil
 }
 
-// GetPublicKey : Get PublicKey bytes from Request.URL
+// GetPublicKey : Get PublicKey bytes from Request.URL, only the DefaultPublicKeyURLs are fetched
 func GetPublicKey(publicKeyURLBase64 string) ([]byte, error) {
-	var bytePublicKey []byte
-	publicKeyURL, err := base64.StdEncoding.DecodeString(publicKeyURLBase64)
-	if err != nil {
-		return nil, err
-	}
-	// fmt.Printf("publicKeyURL={%s}\n", publicKeyURL)
+	return GetPublicKeyContext(context.Background(), publicKeyURLBase64)
+}
 
-	// get PublicKey Content from URL
-	responsePublicKeyURL, err := http.Get(string(publicKeyURL))
+// GetPublicKeyContext : GetPublicKey, aborted when ctx is done
+func GetPublicKeyContext(ctx context.Context, publicKeyURLBase64 string) ([]byte, error) {
+	verifier := NewCallbackVerifier()
+	publicKeyURL, err := verifier.publicKeyURL(publicKeyURLBase64)
 	if err != nil {
-		// fmt.Printf("Get PublicKey Content from URL failed : %s \n", err.Error())
 		return nil, err
 	}
-	bytePublicKey, err = io.ReadAll(responsePublicKeyURL.Body)
-	if err != nil {
-		// fmt.Printf("Read PublicKey Content from URL failed : %s \n", err.Error())
-		return bytePublicKey, err
-	}
-	defer responsePublicKeyURL.Body.Close()
-
-	// fmt.Printf("publicKey={%s}\n", bytePublicKey)
-	return bytePublicKey, nil
+	return verifier.getPublicKey(ctx, publicKeyURL)
 }
 
 // GetAuthorization : decode from Base64String
@@ -155,10 +279,10 @@ func GetAuthorization(strAuthorizationBase64 string) ([]byte, error) {
 	// Get Authorization bytes : decode from Base64String
 	if strAuthorizationBase64 == "" {
 		//fmt.Println("Failed to get authorization field from request header. ")
-		return nil, errors.New("no authorization field in Request header")
+		return nil, ErrMissingAuthorization
 	}
 
-	byteAuthorization, err = base64.StdEncoding.DecodeString(strAuthorizationBase64)
+	byteAuthorization, err = decodeBase64Header(AuthorizationHeader, strAuthorizationBase64)
 	if err != nil {
 		return nil, err
 	}
@@ -166,30 +290,31 @@ func GetAuthorization(strAuthorizationBase64 string) ([]byte, error) {
 }
 
 // GetMD5FromNewAuthString : Get MD5 bytes from Newly Constructed Authorization String.
+// urlPath is the escaped path, e.g. r.URL.EscapedPath(), urlQuery the raw query without "?"
 func GetMD5FromNewAuthString(bodyContent []byte, urlPath string, urlQuery string) ([]byte, error) {
-	var byteMD5 []byte
-	// Construct the New Auth String from URI+Query+Body
-	strCallbackBody := string(bodyContent)
-	// fmt.Printf("r.URL.RawPath={%s}, r.URL.Query()={%s}, strCallbackBody={%s}\n", r.URL.RawPath, r.URL.Query(), strCallbackBody)
-	strURLPathDecode, errUnescape := unescapePath(urlPath, encodePathSegment) //url.PathUnescape(r.URL.Path) for Golang v1.8.2+
-	if errUnescape != nil {
-		// fmt.Printf("url.PathUnescape failed : URL.Path=%s, error=%s \n", r.URL.Path, err.Error())
-		return nil, errUnescape
-	}
-
-	// Generate New Auth String prepare for MD5
-	strAuth := ""
-	if urlQuery == "" {
-		strAuth = fmt.Sprintf("%s\n%s", strURLPathDecode, strCallbackBody)
-	} else {
-		strAuth = fmt.Sprintf("%s?%s\n%s", strURLPathDecode, urlPath, strCallbackBody)
-	}
-	// fmt.Printf("NewlyConstructedAuthString={%s}\n", strAuth)
-
-	// Generate MD5 from the New Auth String
-	md5Ctx := md5.New()
-	md5Ctx.Write([]byte(strAuth))
-	byteMD5 = md5Ctx.Sum(nil)
-
-	return byteMD5, nil
+	return callbackMD5(bodyContent, urlPath, urlQuery, false)
+}
+
+// callbackMD5 : GetMD5FromNewAuthString, forceQuery keeps the "?" of an empty query, e.g. r.URL.ForceQuery
+func callbackMD5(bodyContent []byte, urlPath string, urlQuery string, forceQuery bool) ([]byte, error) {
+	strAuth, err := callbackStringToSign(bodyContent, urlPath, urlQuery, forceQuery)
+	if err != nil {
+		return nil, err
+	}
+	byteMD5 := md5.Sum([]byte(strAuth))
+	return byteMD5[:], nil
+}
+
+// callbackStringToSign : the decoded path, the query as sent, then the body:
+// unescape(path) + ("?" + query) + "\n" + body; %2F is decoded to "/" and "+" is kept in the path
+func callbackStringToSign(bodyContent []byte, urlPath string, urlQuery string, forceQuery bool) (string, error) {
+	strURLPathDecode, err := unescapePath(urlPath, encodePath)
+	if err != nil {
+		return "", err
+	}
+
+	if urlQuery != "" || forceQuery {
+		return strURLPathDecode + "?" + urlQuery + "\n" + string(bodyContent), nil
+	}
+	return strURLPathDecode + "\n" + string(bodyContent), nil
 }
@@ -0,0 +1,130 @@
+package appserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// CallbackFunc : handle the verified callback, OSS relays the returned payload to the uploading client,
+// nil responds {"Status":"OK"}, []byte and json.RawMessage are written as is, anything else is json encoded
+type CallbackFunc func(r *http.Request, body *CallbackBody) (any, error)
+
+// CallbackHandler : verifies the OSS callback, calls Handle and writes its json response
+type CallbackHandler struct {
+	Handle   CallbackFunc
+	Verifier *CallbackVerifier // optional, default: NewCallbackVerifier(), which shares DefaultPublicKeyCache
+}
+
+func NewCallbackHandler(handle CallbackFunc) *CallbackHandler {
+	return &CallbackHandler{Handle: handle}
+}
+
+func (h *CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callbackBody, httpError := verifyCallbackRequest(h.Verifier, r)
+	if httpError != nil {
+		writeJSONError(w, httpError)
+		return
+	}
+
+	var payload any
+	if h.Handle != nil {
+		var err error
+		if payload, err = h.Handle(r, callbackBody); err != nil {
+			var httpError *HTTPError
+			if !errors.As(err, &httpError) {
+				httpError = &HTTPError{Status: http.StatusInternalServerError, Code: "InternalError", Message: "failed to handle callback"}
+			}
+			writeJSONError(w, httpError)
+			return
+		}
+	}
+	if payload == nil {
+		payload = json.RawMessage(`{"Status":"OK"}`)
+	}
+	if b, ok := payload.([]byte); ok {
+		payload = json.RawMessage(b)
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
+type callbackBodyContextKey struct{}
+
+// CallbackMiddleware : verifies the OSS callback before next, which reads the CallbackBody from the request context
+// and the untouched body from req.Body
+func CallbackMiddleware(next http.Handler) http.Handler {
+	return NewCallbackVerifier().Middleware(next)
+}
+
+// Middleware : CallbackMiddleware with the options of the verifier
+func (v *CallbackVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callbackBody, httpError := verifyCallbackRequest(v, r)
+		if httpError != nil {
+			writeJSONError(w, httpError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callbackBodyContextKey{}, callbackBody)))
+	})
+}
+
+// CallbackBodyFromContext : the CallbackBody verified by CallbackMiddleware
+func CallbackBodyFromContext(ctx context.Context) (*CallbackBody, bool) {
+	callbackBody, ok := ctx.Value(callbackBodyContextKey{}).(*CallbackBody)
+	return callbackBody, ok
+}
+
+// callbackErrors : the response of each failure, checked in order with errors.Is,
+// upstream failures of the public key are 5xx, anything else about the callback is 4xx
+var callbackErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrCallbackMethodNotAllowed, http.StatusMethodNotAllowed, "MethodNotAllowed"},
+	{ErrCallbackBodyTooLarge, http.StatusRequestEntityTooLarge, "EntityTooLarge"},
+	{ErrCallbackContentType, http.StatusUnsupportedMediaType, "UnsupportedMediaType"},
+	{ErrMissingHeader, http.StatusBadRequest, "MissingHeader"},
+	{ErrInvalidBase64, http.StatusBadRequest, "InvalidHeader"},
+	{ErrPublicKeyURLNotAllowed, http.StatusForbidden, "PublicKeyNotAllowed"},
+	{ErrPublicKeyNotPinned, http.StatusForbidden, "PublicKeyNotAllowed"},
+	{ErrSignatureMismatch, http.StatusForbidden, "SignatureDoesNotMatch"},
+	{ErrPublicKeyFetchTimeout, http.StatusGatewayTimeout, "PublicKeyFetchTimeout"},
+	{ErrPublicKeyFetch, http.StatusBadGateway, "PublicKeyFetchFailed"},
+	{ErrInvalidPEM, http.StatusBadGateway, "InvalidPublicKey"},
+	{ErrNotRSAPublicKey, http.StatusBadGateway, "InvalidPublicKey"},
+	{ErrCallbackBodyDecode, http.StatusBadRequest, "InvalidCallbackBody"},
+}
+
+// verifyCallbackRequest : req.Body is restored for the handlers after it
+func verifyCallbackRequest(verifier *CallbackVerifier, r *http.Request) (*CallbackBody, *HTTPError) {
+	if verifier == nil {
+		verifier = NewCallbackVerifier()
+	}
+	bodyContent, err := verifier.readBody(r)
+	if err == nil {
+		var callbackBody *CallbackBody
+		if callbackBody, err = verifier.VerifyURLContext(r.Context(), r.URL, r.Header, bodyContent); err == nil {
+			return callbackBody, nil
+		}
+	}
+	return nil, callbackHTTPError(err)
+}
+
+// callbackHTTPError : the HTTPError of a failed verification, see callbackErrors
+func callbackHTTPError(err error) *HTTPError {
+	for _, e := range callbackErrors {
+		if !errors.Is(err, e.err) {
+			continue
+		}
+		message := err.Error()
+		if e.err == ErrSignatureMismatch {
+			message = "callback signature verification failed"
+		} else if e.status >= http.StatusInternalServerError {
+			message = "failed to get callback public key"
+		}
+		return &HTTPError{Status: e.status, Code: e.code, Message: message}
+	}
+	return &HTTPError{Status: http.StatusBadRequest, Code: "InvalidCallback", Message: err.Error()}
+}
@@ -0,0 +1,112 @@
+package appserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testCallbackBody = `{"bucket":"bucket-name","object":"user-dir-prefix/image.jpg","size":2788,"operation":"PostObject","x:user_id":"123"}`
+
+func TestCallbackHandler(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+
+	for name, c := range map[string]struct {
+		handle CallbackFunc
+		expect string
+	}{
+		"default": {nil, `{"Status":"OK"}`},
+		"json": {func(r *http.Request, body *CallbackBody) (any, error) {
+			return map[string]any{"url": "https://cdn.example.com/" + body.Object, "user_id": body.Var("user_id")}, nil
+		}, `{"url":"https://cdn.example.com/user-dir-prefix/image.jpg","user_id":"123"}`},
+		"bytes": {func(r *http.Request, body *CallbackBody) (any, error) {
+			return []byte(`{"size":2788}`), nil
+		}, `{"size":2788}`},
+	} {
+		w := httptest.NewRecorder()
+		NewCallbackHandler(c.handle).ServeHTTP(w, signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody))
+		if w.Code != http.StatusOK || w.Body.String() != c.expect {
+			t.Errorf("%s: expect %s, got %d %s", name, c.expect, w.Code, w.Body)
+		}
+		if w.Header().Get("Content-Type") != "application/json; charset=utf-8" || w.Header().Get("Content-Length") != strconv.Itoa(len(c.expect)) {
+			t.Errorf("%s: headers error %v", name, w.Header())
+		}
+	}
+}
+
+func TestCallbackHandlerErrors(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	handler := NewCallbackHandler(func(r *http.Request, body *CallbackBody) (any, error) {
+		if body.Var("user_id") == "" {
+			return nil, &HTTPError{Status: http.StatusBadRequest, Code: "MissingUser", Message: "missing user"}
+		}
+		return nil, errors.New("database is down")
+	})
+
+	tampered := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	tampered.Body = io.NopCloser(strings.NewReader(strings.Replace(testCallbackBody, "2788", "1", 1)))
+	unsigned := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	unsigned.Header.Del(AuthorizationHeader)
+	get := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	get.Method = http.MethodGet
+	large := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	large.Body = io.NopCloser(strings.NewReader(strings.Repeat(" ", DefaultMaxCallbackBodySize+1)))
+	xml := signer.request(t, "/oss/callback", "application/xml", testCallbackBody)
+
+	for name, c := range map[string]struct {
+		req    *http.Request
+		status int
+		expect string
+	}{
+		"tampered":     {tampered, 403, `{"error":{"code":"SignatureDoesNotMatch","message":"callback signature verification failed"}}`},
+		"unsigned":     {unsigned, 400, `{"error":{"code":"MissingHeader","message":"missing Authorization header"}}`},
+		"large":        {large, 413, `{"error":{"code":"EntityTooLarge","message":"callback body too large: more than 65536 bytes"}}`},
+		"content type": {xml, 415, `{"error":{"code":"UnsupportedMediaType","message":"unsupported callback content type \"application/xml\""}}`},
+		"method":       {get, 405, `{"error":{"code":"MethodNotAllowed","message":"callback method not allowed: GET"}}`},
+		"handle":       {signer.request(t, "/oss/callback", CallbackBodyTypeParam, `{"object":"image.jpg"}`), 400, `{"error":{"code":"MissingUser","message":"missing user"}}`},
+		"handle error": {signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody), 500, `{"error":{"code":"InternalError","message":"failed to handle callback"}}`},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, c.req)
+		if w.Code != c.status || w.Body.String() != c.expect {
+			t.Errorf("%s: expect %d %s, got %d %s", name, c.status, c.expect, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCallbackMiddleware(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+
+	var called bool
+	handler := CallbackMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		callbackBody, ok := CallbackBodyFromContext(r.Context())
+		if !ok || callbackBody.Object != "user-dir-prefix/image.jpg" {
+			t.Error("callback body error", callbackBody)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != testCallbackBody {
+			t.Error("request body must be restored", string(body))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody))
+	if !called || w.Code != http.StatusNoContent {
+		t.Error("middleware error", w.Code)
+	}
+
+	called = false
+	req := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	req.Body = io.NopCloser(strings.NewReader("{}"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if called || w.Code != http.StatusForbidden {
+		t.Error("tampered callback must be rejected", w.Code)
+	}
+}
@@ -1,7 +1,21 @@
 package appserver
 
 import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"github.com/jarcoal/httpmock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -21,3 +35,227 @@ func TestCallbackGetPublicKey(t *testing.T) {
 		t.Errorf("expect %s, got %s", pk, string(resp))
 	}
 }
+
+func TestCallbackBodyVars(t *testing.T) {
+	callbackBody := new(CallbackBody)
+	err := json.Unmarshal([]byte(`{"bucket":"bucket-name","object":"user-dir-prefix/image.jpg","size":2788,"x:user_id":"123","x:album":"travel"}`), callbackBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callbackBody.Object != "user-dir-prefix/image.jpg" || callbackBody.Size != 2788 {
+		t.Error("callback body error", callbackBody)
+	}
+	if callbackBody.Var("user_id") != "123" || callbackBody.Var("x:album") != "travel" || len(callbackBody.Vars) != 2 {
+		t.Error("callback vars error", callbackBody.Vars)
+	}
+}
+
+const testPubKeyURL = "https://gosspublic.alicdn.com/callback_pub_key_v1.pem"
+
+// testCallbackSigner : signs callbacks the way OSS does, with a local key served at testPubKeyURL
+type testCallbackSigner struct {
+	key *rsa.PrivateKey
+	pem []byte
+}
+
+func newTestCallbackSigner(t *testing.T) *testCallbackSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testCallbackSigner{key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})}
+
+	// every signer has its own key at testPubKeyURL
+	DefaultPublicKeyCache.Purge()
+	t.Cleanup(DefaultPublicKeyCache.Purge)
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+	httpmock.RegisterResponder("GET", testPubKeyURL, httpmock.NewBytesResponder(200, s.pem))
+	return s
+}
+
+// sign : base64 rsa-md5 signature of the string to sign
+func (s *testCallbackSigner) sign(t *testing.T, stringToSign string) string {
+	sum := md5.Sum([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.MD5, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// request : callback request to target, signed over the decoded path, the query and the body
+func (s *testCallbackSigner) request(t *testing.T, target string, contentType string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(PubKeyUrlHeader, base64.StdEncoding.EncodeToString([]byte(testPubKeyURL)))
+	stringToSign := req.URL.Path
+	if req.URL.RawQuery != "" || req.URL.ForceQuery {
+		stringToSign += "?" + req.URL.RawQuery
+	}
+	req.Header.Set(AuthorizationHeader, s.sign(t, stringToSign+"\n"+body))
+	return req
+}
+
+// renderCallbackBody : substitute ${var} placeholders as OSS does for the body type, numbers unquoted and
+// empty values null in json
+func renderCallbackBody(template string, bodyType string, values map[string]string) string {
+	numbers := map[string]bool{"size": true, "imageInfo.height": true, "imageInfo.width": true, "crc64": true}
+	return regexp.MustCompile(`\$\{([^}]+)\}`).ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[2 : len(placeholder)-1]
+		value := values[name]
+		if bodyType == CallbackBodyTypeParam && value == "" {
+			return "null"
+		}
+		if bodyType == CallbackBodyTypeParam && numbers[name] {
+			return value
+		}
+		if bodyType == CallbackBodyTypeParam {
+			valueByte, _ := json.Marshal(value)
+			return string(valueByte)
+		}
+		return url.QueryEscape(value)
+	})
+}
+
+func TestCallbackVerifyBodyTypes(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	values := map[string]string{
+		"bucket":           "bucket-name",
+		"object":           "user-dir-prefix/image 1.jpg",
+		"etag":             "A3AC1B2FAADBD0000EE9F5EA57CAACB",
+		"size":             "2788",
+		"mimeType":         "image/jpeg",
+		"imageInfo.height": "197",
+		"imageInfo.width":  "257",
+		"imageInfo.format": "jpg",
+		"crc64":            "34616313172852000",
+		"contentMd5":       "o6wbL6rb0000p9epXyqyw==",
+		"clientIp":         "100.20.30.40",
+		"reqId":            "674EB5AA200000037341888F8",
+		"operation":        "PostObject",
+		"x:user_id":        "123&456",
+	}
+
+	for _, bodyType := range []string{CallbackBodyTypeParam, CallbackBodyTypeForm} {
+		t.Run(bodyType, func(t *testing.T) {
+			token := NewToken(&Config{
+				AccessKeyId:      "yourAccessKeyId",
+				AccessKeySecret:  "yourAccessKeySecret",
+				Host:             "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+				CallbackUrl:      "http://domain.com/oss/callback",
+				CallbackBodyType: bodyType,
+			})
+			tokenPayload, err := token.SetCallbackVars(map[string]string{"user_id": "123&456"}).Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			callbackStr, _ := base64.StdEncoding.DecodeString(tokenPayload.Callback)
+			callback := new(Callback)
+			if err = json.Unmarshal(callbackStr, callback); err != nil {
+				t.Fatal(err)
+			}
+
+			body := renderCallbackBody(callback.CallbackBody, callback.CallbackBodyType, values)
+			req := signer.request(t, "/oss/callback", callback.CallbackBodyType, body)
+			callbackBody, err := NewAliyunOSSCallback(req).VerifySignature()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callbackBody.Object != "user-dir-prefix/image 1.jpg" || callbackBody.Size != 2788 ||
+				callbackBody.ImageInfo.Width != 257 || callbackBody.Operation != "PostObject" || callbackBody.VpcId != nil {
+				t.Error("callback body error", callbackBody)
+			}
+			if callbackBody.Var("user_id") != "123&456" {
+				t.Error("callback vars error", callbackBody.Vars)
+			}
+		})
+	}
+}
+
+func TestDecodeCallbackBody(t *testing.T) {
+	callbackBody, err := DecodeCallbackBody("", []byte("bucket=bucket-name&size=&crc64=18446744073709551615"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callbackBody.Bucket != "bucket-name" || callbackBody.Size != 0 || callbackBody.Crc64 != 18446744073709551615 {
+		t.Error("callback body error", callbackBody)
+	}
+	if _, err = DecodeCallbackBody(CallbackBodyTypeForm, []byte("size=large")); err == nil {
+		t.Error("invalid size")
+	}
+	if _, err = DecodeCallbackBody("text/plain", []byte("bucket")); err == nil {
+		t.Error("unsupported content type")
+	}
+}
+
+// TestCallbackStringToSign : golden vectors of the OSS demo servers, unquote(path) + query as sent + "\n" + body
+func TestCallbackStringToSign(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	for _, c := range []struct {
+		target string
+		expect string
+		md5    string
+	}{
+		{"/oss/callback", "/oss/callback", "bc3e24546dff5e76900725732545830b"},
+		{"/oss/callback?user_id=123&sig=a%2Bb", "/oss/callback?user_id=123&sig=a%2Bb", "a05254e3f5f6edd720294a4ccc00f44c"},
+		{"/oss/%E5%9B%9E%E8%B0%83/callback", "/oss/回调/callback", "91c447efbd5ca28ff1373f17b9c7767c"},
+		{"/oss/a%2Fb/callback", "/oss/a/b/callback", "fe5e2f3dc74f3785149ffee0d1357628"},
+		{"/oss/call%20back+x?q=a+b&empty=", "/oss/call back+x?q=a+b&empty=", "391aa93918c67a32253c7aa66579c82a"},
+		{"/oss/callback?", "/oss/callback?", "f4b371f446c68da9b59d20a868f1aa7e"},
+		{"/oss/a%3Fb/callback?q=1", "/oss/a?b/callback?q=1", "7ef1eda28cb715580f45358958a31104"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, c.target, nil)
+		stringToSign, err := callbackStringToSign([]byte(testCallbackBody), req.URL.EscapedPath(), req.URL.RawQuery, req.URL.ForceQuery)
+		if err != nil || stringToSign != c.expect+"\n"+testCallbackBody {
+			t.Errorf("%s: expect %s, got %s %v", c.target, c.expect, stringToSign, err)
+		}
+		byteMd5, err := callbackMD5([]byte(testCallbackBody), req.URL.EscapedPath(), req.URL.RawQuery, req.URL.ForceQuery)
+		if err != nil || hex.EncodeToString(byteMd5) != c.md5 {
+			t.Errorf("%s: expect md5 %s, got %x %v", c.target, c.md5, byteMd5, err)
+		}
+
+		// signed over the golden string, not by the code under test
+		req = signer.request(t, c.target, CallbackBodyTypeParam, testCallbackBody)
+		req.Header.Set(AuthorizationHeader, signer.sign(t, c.expect+"\n"+testCallbackBody))
+		if _, err = NewAliyunOSSCallback(req).VerifySignature(); err != nil {
+			t.Errorf("%s: %v", c.target, err)
+		}
+		requestURL, err := url.ParseRequestURI(req.RequestURI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = NewCallbackVerifier().VerifyURL(requestURL, req.Header, []byte(testCallbackBody)); err != nil {
+			t.Errorf("%s: raw parts: %v", c.target, err)
+		}
+		w := httptest.NewRecorder()
+		req = signer.request(t, c.target, CallbackBodyTypeParam, testCallbackBody)
+		req.Header.Set(AuthorizationHeader, signer.sign(t, c.expect+"\n"+testCallbackBody))
+		NewCallbackHandler(nil).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: handler %d %s", c.target, w.Code, w.Body)
+		}
+	}
+
+	if byteMd5, err := GetMD5FromNewAuthString([]byte(testCallbackBody), "/oss/callback", "user_id=123&sig=a%2Bb"); err != nil || hex.EncodeToString(byteMd5) != "a05254e3f5f6edd720294a4ccc00f44c" {
+		t.Errorf("expect md5 a05254e3f5f6edd720294a4ccc00f44c, got %x %v", byteMd5, err)
+	}
+	if _, err := GetMD5FromNewAuthString([]byte(testCallbackBody), "/oss/%zz", ""); err == nil || err.Error() != `invalid URL escape "%zz"` {
+		t.Error("invalid escape error", err)
+	}
+	for mode, expect := range map[encoding]string{encodePath: "/a+b c", encodeQueryComponent: "/a b c"} {
+		if got, _ := unescapePath("/a+b%20c", mode); got != expect {
+			t.Errorf("mode %d: expect %s, got %s", mode, expect, got)
+		}
+	}
+	if stringToSign, _ := callbackStringToSign(nil, "/oss/callback", "a=1", false); stringToSign != "/oss/callback?a=1\n" {
+		t.Error("query must follow the path", stringToSign)
+	}
+	if stringToSign, _ := callbackStringToSign(nil, "/oss/callback", "", true); stringToSign != "/oss/callback?\n" {
+		t.Error("forced query must keep the ?", stringToSign)
+	}
+}
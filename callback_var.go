@@ -0,0 +1,111 @@
+package appserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const CallbackVarPrefix = "x:"
+
+// normalizeCallbackVars : "user_id" and "x:user_id" both become "x:user_id"
+// https://help.aliyun.com/zh/oss/developer-reference/callback#section-btz-phx-wdb
+func normalizeCallbackVars(vars map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(vars))
+	for k, v := range vars {
+		name := strings.TrimPrefix(k, CallbackVarPrefix)
+		if name == "" {
+			return nil, configErrorf("CallbackVars", "missing required callback var name")
+		}
+		for _, c := range name {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+				return nil, configErrorf("CallbackVars", "invalid character %q in callback var %q, must be lower-case", c, k)
+			}
+		}
+		normalized[CallbackVarPrefix+name] = v
+	}
+	return normalized, nil
+}
+
+func sortedCallbackVarNames(vars map[string]string) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withCallbackVars : copy of the callback whose body carries the ${x:...} placeholders not already in it
+func (c *Callback) withCallbackVars(vars map[string]string) (*Callback, error) {
+	k := *c
+	for _, name := range sortedCallbackVarNames(vars) {
+		placeholder := "${" + name + "}"
+		if strings.Contains(k.CallbackBody, placeholder) {
+			continue
+		}
+		switch k.CallbackBodyType {
+		case "", CallbackBodyTypeForm:
+			if k.CallbackBody != "" {
+				k.CallbackBody += "&"
+			}
+			k.CallbackBody += name + "=" + placeholder
+		case CallbackBodyTypeParam:
+			body := strings.TrimSpace(k.CallbackBody)
+			if !strings.HasSuffix(body, "}") {
+				return nil, configErrorf("CallbackBody", "callback body is not a json object")
+			}
+			body = strings.TrimSpace(body[:len(body)-1])
+			if !strings.HasSuffix(body, "{") {
+				body += ","
+			}
+			k.CallbackBody = body + `"` + name + `":` + placeholder + "}"
+		default:
+			return nil, configErrorf("CallbackBodyType", "unsupported callback body type %q", k.CallbackBodyType)
+		}
+	}
+	return &k, nil
+}
+
+// encodeCallback : base64 callback and callback-var, both empty without a callback; the vars are validated
+// and wired into the callback body
+func encodeCallback(callback *Callback, vars map[string]string) (string, string, error) {
+	if callback == nil {
+		if len(vars) > 0 {
+			return "", "", configErrorf("CallbackVars", "callback vars require a callback")
+		}
+		return "", "", nil
+	}
+	if err := callback.Validate(); err != nil {
+		return "", "", err
+	}
+
+	var callbackVarBase64 string
+	if len(vars) > 0 {
+		normalized, err := normalizeCallbackVars(vars)
+		if err != nil {
+			return "", "", err
+		}
+		if callback, err = callback.withCallbackVars(normalized); err != nil {
+			return "", "", err
+		}
+		if callbackVarBase64, err = encodeCallbackVars(normalized); err != nil {
+			return "", "", err
+		}
+	}
+	callbackByte, err := json.Marshal(callback)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(callbackByte), callbackVarBase64, nil
+}
+
+// encodeCallbackVars : base64 json, the callback-var query param of PutObject and CompleteMultipartUpload
+func encodeCallbackVars(vars map[string]string) (string, error) {
+	varsByte, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(varsByte), nil
+}
@@ -0,0 +1,299 @@
+package appserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultPublicKeyFetchTimeout = 5 * time.Second
+const MaxPublicKeySize = 16 << 10
+
+// DefaultMaxCallbackBodySize : OSS callback bodies are the callbackBody template with its vars filled in
+const DefaultMaxCallbackBodySize = 64 << 10
+
+// DefaultPublicKeyURLs : the OSS callback public keys, the pub key url header may only name one of them;
+// each one is embedded in NewDefaultPublicKeyRing, allow a new key url with AllowedPublicKeyURLs and pin it with AddPEM
+// https://help.aliyun.com/zh/oss/developer-reference/callback
+var DefaultPublicKeyURLs = []string{
+	"https://gosspublic.alicdn.com/callback_pub_key_v1.pem",
+}
+
+// CallbackVerifier : verifies a callback from its raw parts, for servers without net/http
+// and for callbacks captured and replayed from a queue; the zero value is ready to use
+type CallbackVerifier struct {
+	// PublicKeyCache : optional, nil: the public key is fetched for every callback
+	PublicKeyCache *PublicKeyCache
+	// AllowedPublicKeyURLs : optional, default: DefaultPublicKeyURLs, exact urls, the pub key url header is checked
+	// against them before anything is fetched
+	AllowedPublicKeyURLs []string
+	// PublicKeyFetchTimeout : optional, default: 5s, applied on top of the context
+	PublicKeyFetchTimeout time.Duration
+	// HTTPClient : optional, e.g. with a proxy or a custom transport, redirects are rejected regardless
+	HTTPClient *http.Client
+	// PinnedPublicKeys : optional, verify with these keys only and never fetch, e.g. NewDefaultPublicKeyRing()
+	PinnedPublicKeys *PublicKeyRing
+	// MaxBodySize : optional, default: 64KB, larger bodies are rejected unread
+	MaxBodySize int64
+}
+
+func NewCallbackVerifier() *CallbackVerifier {
+	return &CallbackVerifier{PublicKeyCache: DefaultPublicKeyCache}
+}
+
+// Verify : rawPath is the path as sent, still percent-encoded, rawQuery without "?";
+// header names are matched case-insensitively; use VerifyURL when the url may end with a bare "?"
+func (v *CallbackVerifier) Verify(rawPath string, rawQuery string, header http.Header, body []byte) (*CallbackBody, error) {
+	return v.VerifyContext(context.Background(), rawPath, rawQuery, header, body)
+}
+
+// VerifyContext : Verify, the public key fetch is aborted when ctx is done;
+// the body, the Content-Type and the headers are checked before anything is fetched, OSS always sends a Content-Type
+func (v *CallbackVerifier) VerifyContext(ctx context.Context, rawPath string, rawQuery string, header http.Header, body []byte) (*CallbackBody, error) {
+	return v.verifyContext(ctx, rawPath, rawQuery, false, header, body)
+}
+
+// VerifyURL : Verify for a request url, e.g. url.ParseRequestURI of the request uri as sent,
+// the "?" of an empty query is signed as well
+func (v *CallbackVerifier) VerifyURL(u *url.URL, header http.Header, body []byte) (*CallbackBody, error) {
+	return v.VerifyURLContext(context.Background(), u, header, body)
+}
+
+// VerifyURLContext : VerifyURL, the public key fetch is aborted when ctx is done
+func (v *CallbackVerifier) VerifyURLContext(ctx context.Context, u *url.URL, header http.Header, body []byte) (*CallbackBody, error) {
+	return v.verifyContext(ctx, u.EscapedPath(), u.RawQuery, u.ForceQuery, header, body)
+}
+
+func (v *CallbackVerifier) verifyContext(ctx context.Context, rawPath string, rawQuery string, forceQuery bool, header http.Header, body []byte) (*CallbackBody, error) {
+	if int64(len(body)) > v.maxBodySize() {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrCallbackBodyTooLarge, v.maxBodySize())
+	}
+	contentType := headerValue(header, "Content-Type")
+	if contentType == "" {
+		return nil, fmt.Errorf("%w: missing Content-Type", ErrCallbackContentType)
+	}
+	if _, err := callbackMediaType(contentType, body); err != nil {
+		return nil, err
+	}
+	authorization, err := GetAuthorization(headerValue(header, AuthorizationHeader))
+	if err != nil {
+		return nil, err
+	}
+	publicKeyURLBase64 := headerValue(header, PubKeyUrlHeader)
+	if publicKeyURLBase64 == "" {
+		return nil, ErrMissingPublicKeyURL
+	}
+
+	byteMd5, err := callbackMD5(body, rawPath, rawQuery, forceQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys, err := v.publicKeys(ctx, publicKeyURLBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	// any of the keys, pinned keys overlap during a rotation
+	err = ErrPublicKeyNotPinned
+	for _, publicKey := range publicKeys {
+		if err = verifySignature(publicKey, byteMd5, authorization); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeCallbackBody(contentType, body)
+}
+
+// readBody : the body of a POST callback, at most MaxBodySize bytes; r.Body is closed and restored for the handlers after it
+func (v *CallbackVerifier) readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w: %s", ErrCallbackMethodNotAllowed, r.Method)
+	}
+	maxBodySize := v.maxBodySize()
+	if r.ContentLength > maxBodySize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrCallbackBodyTooLarge, maxBodySize)
+	}
+	bodyContent, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bodyContent)) > maxBodySize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrCallbackBodyTooLarge, maxBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(bodyContent))
+	return bodyContent, nil
+}
+
+func (v *CallbackVerifier) maxBodySize() int64 {
+	if v.MaxBodySize > 0 {
+		return v.MaxBodySize
+	}
+	return DefaultMaxCallbackBodySize
+}
+
+func (v *CallbackVerifier) publicKeys(ctx context.Context, publicKeyURLBase64 string) ([]*rsa.PublicKey, error) {
+	if v.PinnedPublicKeys != nil {
+		publicKeyURL, err := decodeBase64Header(PubKeyUrlHeader, publicKeyURLBase64)
+		if err != nil {
+			return nil, err
+		}
+		return v.PinnedPublicKeys.Keys(string(publicKeyURL))
+	}
+	publicKey, err := v.publicKey(ctx, publicKeyURLBase64)
+	if err != nil {
+		return nil, err
+	}
+	return []*rsa.PublicKey{publicKey}, nil
+}
+
+func (v *CallbackVerifier) publicKey(ctx context.Context, publicKeyURLBase64 string) (*rsa.PublicKey, error) {
+	publicKeyURL, err := v.publicKeyURL(publicKeyURLBase64)
+	if err != nil {
+		return nil, err
+	}
+	if v.PublicKeyCache == nil {
+		return fetchPublicKey(func() ([]byte, error) {
+			return v.getPublicKey(ctx, publicKeyURL)
+		})
+	}
+	// the fetch is shared with the concurrent callbacks, it only stops at PublicKeyFetchTimeout
+	return v.PublicKeyCache.GetContext(ctx, publicKeyURL, func() ([]byte, error) {
+		return v.getPublicKey(detachedContext{ctx}, publicKeyURL)
+	})
+}
+
+// publicKeyURL : the decoded pub key url header if it is allowed, http is upgraded to an allowed https url
+func (v *CallbackVerifier) publicKeyURL(publicKeyURLBase64 string) (string, error) {
+	publicKeyURLByte, err := decodeBase64Header(PubKeyUrlHeader, publicKeyURLBase64)
+	if err != nil {
+		return "", err
+	}
+	publicKeyURL := string(publicKeyURLByte)
+	allowed := v.AllowedPublicKeyURLs
+	if len(allowed) == 0 {
+		allowed = DefaultPublicKeyURLs
+	}
+
+	for _, candidate := range publicKeyURLCandidates(publicKeyURL) {
+		for _, allowedURL := range allowed {
+			if candidate == allowedURL {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrPublicKeyURLNotAllowed, publicKeyURL)
+}
+
+// publicKeyURLCandidates : the url, preceded by its https version when it is http
+func publicKeyURLCandidates(publicKeyURL string) []string {
+	if strings.HasPrefix(publicKeyURL, "http://") {
+		return []string{"https://" + strings.TrimPrefix(publicKeyURL, "http://"), publicKeyURL}
+	}
+	return []string{publicKeyURL}
+}
+
+// getPublicKey : no redirects, at most MaxPublicKeySize bytes, within PublicKeyFetchTimeout
+func (v *CallbackVerifier) getPublicKey(ctx context.Context, publicKeyURL string) ([]byte, error) {
+	timeout := v.PublicKeyFetchTimeout
+	if timeout <= 0 {
+		timeout = DefaultPublicKeyFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var client http.Client
+	if v.HTTPClient != nil {
+		client = *v.HTTPClient
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return ErrPublicKeyRedirect
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, publicKeyURL, nil)
+	if err != nil {
+		return nil, publicKeyFetchError(publicKeyURL, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, publicKeyFetchError(publicKeyURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, publicKeyFetchError(publicKeyURL, fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	bytePublicKey, err := io.ReadAll(io.LimitReader(resp.Body, MaxPublicKeySize+1))
+	if err != nil {
+		return nil, publicKeyFetchError(publicKeyURL, err)
+	}
+	if len(bytePublicKey) > MaxPublicKeySize {
+		return nil, publicKeyFetchError(publicKeyURL, fmt.Errorf("%w: more than %d bytes", ErrPublicKeyTooLarge, MaxPublicKeySize))
+	}
+	return bytePublicKey, nil
+}
+
+func publicKeyFetchError(publicKeyURL string, err error) error {
+	var netError net.Error
+	var urlError *url.Error
+	switch {
+	case errors.Is(err, ErrPublicKeyRedirect):
+		err = ErrPublicKeyRedirect
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netError) && netError.Timeout():
+		err = fmt.Errorf("%w: %v", ErrPublicKeyFetchTimeout, err)
+	case errors.As(err, &urlError):
+		err = urlError.Err
+	}
+	return &PublicKeyFetchError{URL: publicKeyURL, Err: err}
+}
+
+// detachedContext : the values of the context without its cancellation and deadline
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// decodeBase64Header : a *HeaderError when the value is not base64
+func decodeBase64Header(name string, value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, &HeaderError{Header: name, Err: &kindError{kind: ErrInvalidBase64, err: err}}
+	}
+	return decoded, nil
+}
+
+// headerValue : header.Get, falling back to a case-insensitive match for maps not built with canonical keys
+func headerValue(header http.Header, name string) string {
+	if value := header.Get(name); value != "" {
+		return value
+	}
+	for k, values := range header {
+		if strings.EqualFold(k, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,281 @@
+package appserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackVerifierVerify(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+
+	// captured from a queue, header names as they were received
+	header := http.Header{
+		"content-type":       {CallbackBodyTypeParam},
+		"x-oss-pub-key-url":  {base64.StdEncoding.EncodeToString([]byte(testPubKeyURL))},
+		"authorization":      {signer.sign(t, "/oss/call back\n"+testCallbackBody)},
+		"x-oss-bucket-owner": {"1234567890"},
+	}
+	callbackBody, err := NewCallbackVerifier().Verify("/oss/call%20back", "", header, []byte(testCallbackBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callbackBody.Object != "user-dir-prefix/image.jpg" || callbackBody.Var("user_id") != "123" {
+		t.Error("callback body error", callbackBody)
+	}
+
+	var verifier CallbackVerifier
+	if _, err = verifier.Verify("/oss/call%20back", "", header, []byte(`{"object":"other.jpg"}`)); err == nil {
+		t.Error("tampered body must be rejected")
+	}
+	if _, err = verifier.Verify("/oss/other", "", header, []byte(testCallbackBody)); err == nil {
+		t.Error("other path must be rejected")
+	}
+}
+
+func TestAliyunOSSCallbackWrapsVerifier(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	req := signer.request(t, "/oss/call%20back", CallbackBodyTypeParam, testCallbackBody)
+	callbackBody, err := NewAliyunOSSCallback(req).VerifySignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callbackBody.Object != "user-dir-prefix/image.jpg" {
+		t.Error("callback body error", callbackBody)
+	}
+}
+
+func TestCallbackVerifierPublicKeyURL(t *testing.T) {
+	var verifier CallbackVerifier
+	for publicKeyURL, expect := range map[string]string{
+		"https://gosspublic.alicdn.com/callback_pub_key_v1.pem": "https://gosspublic.alicdn.com/callback_pub_key_v1.pem",
+		"http://gosspublic.alicdn.com/callback_pub_key_v1.pem":  "https://gosspublic.alicdn.com/callback_pub_key_v1.pem",
+		"https://gosspublic.alicdn.com/callback_pub_key_v3.pem": "",
+		"https://gosspublic.alicdn.com.evil.com/key.pem":        "",
+		"http://100.100.100.200/latest/meta-data/":              "",
+		"https://127.0.0.1/callback_pub_key_v1.pem":             "",
+	} {
+		got, err := verifier.publicKeyURL(base64.StdEncoding.EncodeToString([]byte(publicKeyURL)))
+		if expect == "" && !errors.Is(err, ErrPublicKeyURLNotAllowed) {
+			t.Errorf("%s: expect not allowed, got %v", publicKeyURL, err)
+		}
+		if got != expect {
+			t.Errorf("%s: expect %s, got %s", publicKeyURL, expect, got)
+		}
+	}
+
+	// a disallowed url is rejected before any fetch
+	header := http.Header{}
+	header.Set(PubKeyUrlHeader, base64.StdEncoding.EncodeToString([]byte("http://100.100.100.200/latest/meta-data/")))
+	header.Set(AuthorizationHeader, "c2lnbmF0dXJl")
+	header.Set("Content-Type", CallbackBodyTypeParam)
+	if _, err := verifier.Verify("/oss/callback", "", header, []byte(testCallbackBody)); !errors.Is(err, ErrPublicKeyURLNotAllowed) {
+		t.Error("not allowed error", err)
+	}
+}
+
+func TestCallbackVerifierGetPublicKey(t *testing.T) {
+	publicKeyPEM := newTestPublicKeyPEM(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(publicKeyPEM)
+	})
+	mux.HandleFunc("/redirect.pem", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/key.pem", http.StatusFound)
+	})
+	mux.HandleFunc("/large.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("A"), MaxPublicKeySize+1))
+	})
+	mux.HandleFunc("/slow.pem", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	verifier := &CallbackVerifier{
+		AllowedPublicKeyURLs: []string{
+			server.URL + "/key.pem", server.URL + "/redirect.pem", server.URL + "/large.pem", server.URL + "/slow.pem",
+		},
+		PublicKeyFetchTimeout: 50 * time.Millisecond,
+	}
+	for path, expect := range map[string]error{
+		"/key.pem":      nil,
+		"/redirect.pem": ErrPublicKeyRedirect,
+		"/large.pem":    ErrPublicKeyTooLarge,
+		"/slow.pem":     ErrPublicKeyFetchTimeout,
+	} {
+		key, err := verifier.publicKey(context.Background(), base64.StdEncoding.EncodeToString([]byte(server.URL+path)))
+		if !errors.Is(err, expect) {
+			t.Errorf("%s: expect %v, got %v", path, expect, err)
+		}
+		if expect == nil && key == nil {
+			t.Errorf("%s: missing key", path)
+		}
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCallbackVerifierContext(t *testing.T) {
+	publicKeyPEM := newTestPublicKeyPEM(t)
+	started := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(publicKeyPEM)
+	})
+	mux.HandleFunc("/slow.pem", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	transport := &countingTransport{}
+	verifier := &CallbackVerifier{
+		AllowedPublicKeyURLs: []string{server.URL + "/key.pem", server.URL + "/slow.pem"},
+		HTTPClient:           &http.Client{Transport: transport},
+	}
+	if _, err := verifier.publicKey(context.Background(), base64.StdEncoding.EncodeToString([]byte(server.URL+"/key.pem"))); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests != 1 {
+		t.Error("http client must be used", transport.requests)
+	}
+
+	// the request is gone, the fetch is aborted long before the 5s fetch timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	start := time.Now()
+	_, err := verifier.publicKey(ctx, base64.StdEncoding.EncodeToString([]byte(server.URL+"/slow.pem")))
+	if !errors.Is(err, context.Canceled) {
+		t.Error("canceled error", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("fetch must be aborted")
+	}
+
+	cancel()
+	if _, err = GetPublicKeyContext(ctx, base64.StdEncoding.EncodeToString([]byte(DefaultPublicKeyURLs[0]))); !errors.Is(err, context.Canceled) {
+		t.Error("canceled error", err)
+	}
+}
+
+func TestAliyunOSSCallbackSetVerifier(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	req := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	ring := NewPublicKeyRing()
+	ring.Add(testPubKeyURL, &signer.key.PublicKey)
+	callback := NewAliyunOSSCallback(req).SetVerifier(&CallbackVerifier{PinnedPublicKeys: ring})
+	if _, err := callback.VerifySignatureContext(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCallbackVerifierRequestChecks(t *testing.T) {
+	signer := newTestCallbackSigner(t)
+	transport := &countingTransport{}
+	verifier := &CallbackVerifier{HTTPClient: &http.Client{Transport: transport}, MaxBodySize: 256}
+
+	get := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	get.Method = http.MethodGet
+	chunked := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	chunked.ContentLength = -1
+	chunked.Body = io.NopCloser(strings.NewReader(strings.Repeat(" ", 257)))
+	xml := signer.request(t, "/oss/callback", "application/xml", testCallbackBody)
+	noContentType := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	noContentType.Header.Del("Content-Type")
+	unsigned := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	unsigned.Header.Del(AuthorizationHeader)
+	badAuthorization := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	badAuthorization.Header.Set(AuthorizationHeader, "not base64")
+	noPublicKeyURL := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	noPublicKeyURL.Header.Del(PubKeyUrlHeader)
+
+	for name, c := range map[string]struct {
+		req    *http.Request
+		expect error
+	}{
+		"method":          {get, ErrCallbackMethodNotAllowed},
+		"large":           {chunked, ErrCallbackBodyTooLarge},
+		"content type":    {xml, ErrCallbackContentType},
+		"no content type": {noContentType, ErrCallbackContentType},
+		"authorization":   {unsigned, ErrMissingAuthorization},
+		"pub key url":     {noPublicKeyURL, ErrMissingPublicKeyURL},
+	} {
+		if _, err := NewAliyunOSSCallback(c.req).SetVerifier(verifier).VerifySignature(); !errors.Is(err, c.expect) {
+			t.Errorf("%s: expect %v, got %v", name, c.expect, err)
+		}
+	}
+	if _, err := NewAliyunOSSCallback(badAuthorization).SetVerifier(verifier).VerifySignature(); err == nil {
+		t.Error("bad authorization must be rejected")
+	}
+	if transport.requests != 0 {
+		t.Error("checks must run before any fetch", transport.requests)
+	}
+}
+
+func TestCallbackVerifierSharedFetch(t *testing.T) {
+	publicKeyPEM := newTestPublicKeyPEM(t)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key.pem", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write(publicKeyPEM)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cache := NewPublicKeyCache(0)
+	verifier := &CallbackVerifier{PublicKeyCache: cache, AllowedPublicKeyURLs: []string{server.URL + "/key.pem"}}
+	publicKeyURLBase64 := base64.StdEncoding.EncodeToString([]byte(server.URL + "/key.pem"))
+
+	// the callback that started the fetch is gone, the one waiting for it still gets the key
+	ctx, cancel := context.WithCancel(context.Background())
+	starterErr := make(chan error, 1)
+	go func() {
+		_, err := verifier.publicKey(ctx, publicKeyURLBase64)
+		starterErr <- err
+	}()
+	<-started
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err := verifier.publicKey(context.Background(), publicKeyURLBase64)
+		waiterErr <- err
+	}()
+	cancel()
+	if err := <-starterErr; !errors.Is(err, context.Canceled) {
+		t.Error("canceled error", err)
+	}
+	close(release)
+	if err := <-waiterErr; err != nil {
+		t.Error("shared fetch must not be canceled", err)
+	}
+	if stats := cache.Stats(); stats.FetchErrors != 0 || stats.Misses != 1 {
+		t.Error("shared fetch stats error", stats)
+	}
+}
@@ -0,0 +1,538 @@
+package appserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCredentialsRefreshBefore : longer than DefaultExpireSecond, a policy or presign must not outlive the credentials
+const DefaultCredentialsRefreshBefore = 15 * time.Minute
+const DefaultECSMetadataEndpoint = "http://100.100.100.200"
+const DefaultECSMetadataRetryAfter = time.Minute
+const DefaultSTSEndpoint = "https://sts.aliyuncs.com"
+
+// Credentials
+// https://help.aliyun.com/zh/sdk/developer-reference/v2-manage-go-access-credentials
+type Credentials struct {
+	AccessKeyId     string    `json:"access_key_id"`
+	AccessKeySecret string    `json:"access_key_secret"`
+	SecurityToken   string    `json:"security_token"` // optional, sts only
+	Expiration      time.Time `json:"expiration"`     // optional, zero for long-lived keys
+}
+
+func (c *Credentials) Validate() error {
+	if c.AccessKeyId == "" {
+		return configErrorf("access_key_id", "missing required credentials access_key_id")
+	}
+	if c.AccessKeySecret == "" {
+		return configErrorf("access_key_secret", "missing required credentials access_key_secret")
+	}
+	return nil
+}
+
+// expiresWithin : temporary credentials expiring within d must be refreshed
+func (c *Credentials) expiresWithin(d time.Duration) bool {
+	return !c.Expiration.IsZero() && time.Now().Add(d).After(c.Expiration)
+}
+
+// CredentialsProvider is asked for credentials by every Token.Generate call
+type CredentialsProvider interface {
+	GetCredentials() (*Credentials, error)
+}
+
+// CredentialsProviderFunc : adapter to use an ordinary function as a CredentialsProvider
+type CredentialsProviderFunc func() (*Credentials, error)
+
+func (f CredentialsProviderFunc) GetCredentials() (*Credentials, error) {
+	return f()
+}
+
+// credentialsCache : holds temporary credentials until they are about to expire
+type credentialsCache struct {
+	mu          sync.Mutex
+	credentials *Credentials
+}
+
+// get : fetch new credentials when missing or expiring within refreshBefore, default: DefaultCredentialsRefreshBefore,
+// a failed refresh keeps serving the still valid ones
+func (c *credentialsCache) get(refreshBefore time.Duration, fetch func() (*Credentials, error)) (*Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if refreshBefore <= 0 {
+		refreshBefore = DefaultCredentialsRefreshBefore
+	}
+	if c.credentials != nil && !c.credentials.expiresWithin(refreshBefore) {
+		return c.credentials, nil
+	}
+	credentials, err := fetch()
+	if err != nil {
+		if c.credentials != nil && !c.credentials.expiresWithin(0) {
+			return c.credentials, nil
+		}
+		return nil, err
+	}
+	if err = credentials.Validate(); err != nil {
+		return nil, err
+	}
+	c.credentials = credentials
+	return credentials, nil
+}
+
+// CachedCredentialsProvider : caches credentials of any provider and refreshes them before they expire
+type CachedCredentialsProvider struct {
+	// RefreshBefore : optional, default: 15m, at least the longest ExpireSecond and presign Expires in use
+	RefreshBefore time.Duration
+
+	provider CredentialsProvider
+	cache    credentialsCache
+}
+
+func NewCachedCredentialsProvider(provider CredentialsProvider) *CachedCredentialsProvider {
+	return &CachedCredentialsProvider{provider: provider}
+}
+
+func (p *CachedCredentialsProvider) GetCredentials() (*Credentials, error) {
+	return p.cache.get(p.RefreshBefore, p.provider.GetCredentials)
+}
+
+// StaticCredentialsProvider : fixed AccessKey or STS credentials
+type StaticCredentialsProvider struct {
+	credentials Credentials
+}
+
+func NewStaticCredentialsProvider(accessKeyId string, accessKeySecret string, securityToken string) *StaticCredentialsProvider {
+	return &StaticCredentialsProvider{credentials: Credentials{
+		AccessKeyId:     accessKeyId,
+		AccessKeySecret: accessKeySecret,
+		SecurityToken:   securityToken,
+	}}
+}
+
+func (p *StaticCredentialsProvider) GetCredentials() (*Credentials, error) {
+	if err := p.credentials.Validate(); err != nil {
+		return nil, err
+	}
+	credentials := p.credentials
+	return &credentials, nil
+}
+
+// EnvironmentCredentialsProvider : OSS_ACCESS_KEY_ID, OSS_ACCESS_KEY_SECRET, OSS_SESSION_TOKEN,
+// falls back to ALIBABA_CLOUD_ACCESS_KEY_ID, ALIBABA_CLOUD_ACCESS_KEY_SECRET, ALIBABA_CLOUD_SECURITY_TOKEN
+type EnvironmentCredentialsProvider struct{}
+
+func NewEnvironmentCredentialsProvider() *EnvironmentCredentialsProvider {
+	return &EnvironmentCredentialsProvider{}
+}
+
+func (p *EnvironmentCredentialsProvider) GetCredentials() (*Credentials, error) {
+	credentials := &Credentials{
+		AccessKeyId:     os.Getenv("OSS_ACCESS_KEY_ID"),
+		AccessKeySecret: os.Getenv("OSS_ACCESS_KEY_SECRET"),
+		SecurityToken:   os.Getenv("OSS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyId == "" {
+		credentials = &Credentials{
+			AccessKeyId:     os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID"),
+			AccessKeySecret: os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET"),
+			SecurityToken:   os.Getenv("ALIBABA_CLOUD_SECURITY_TOKEN"),
+		}
+	}
+	if err := credentials.Validate(); err != nil {
+		return nil, fmt.Errorf("environment credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// ProfileCredentialsProvider : shared credentials file, default: ~/.alibabacloud/credentials
+//
+//	[default]
+//	type = access_key
+//	access_key_id = yourAccessKeyId
+//	access_key_secret = yourAccessKeySecret
+type ProfileCredentialsProvider struct {
+	path    string
+	profile string
+}
+
+// NewProfileCredentialsProvider : empty path and profile fall back to
+// ALIBABA_CLOUD_CREDENTIALS_FILE and ALIBABA_CLOUD_PROFILE, then to the defaults
+func NewProfileCredentialsProvider(path string, profile string) *ProfileCredentialsProvider {
+	if path == "" {
+		path = os.Getenv("ALIBABA_CLOUD_CREDENTIALS_FILE")
+	}
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".alibabacloud", "credentials")
+		}
+	}
+	if profile == "" {
+		profile = os.Getenv("ALIBABA_CLOUD_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	return &ProfileCredentialsProvider{path: path, profile: profile}
+}
+
+func (p *ProfileCredentialsProvider) GetCredentials() (*Credentials, error) {
+	sections, err := readIniFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("profile credentials: %w", err)
+	}
+	section, ok := sections[p.profile]
+	if !ok {
+		return nil, fmt.Errorf("profile credentials: missing profile %q in %s", p.profile, p.path)
+	}
+
+	credentials := &Credentials{
+		AccessKeyId:     section["access_key_id"],
+		AccessKeySecret: section["access_key_secret"],
+	}
+	switch section["type"] {
+	case "", "access_key":
+	case "sts":
+		credentials.SecurityToken = section["security_token"]
+	default:
+		return nil, fmt.Errorf("profile credentials: unsupported type %q in profile %q", section["type"], p.profile)
+	}
+	if err = credentials.Validate(); err != nil {
+		return nil, fmt.Errorf("profile credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// readIniFile : section name => key => value
+func readIniFile(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]map[string]string)
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = make(map[string]string)
+			sections[strings.TrimSpace(line[1:len(line)-1])] = section
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section == nil {
+			continue
+		}
+		section[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return sections, scanner.Err()
+}
+
+// ECSRAMRoleCredentialsProvider : temporary credentials of the RAM role attached to the ECS instance
+// https://help.aliyun.com/zh/ecs/user-guide/attach-an-instance-ram-role-to-an-ecs-instance
+type ECSRAMRoleCredentialsProvider struct {
+	// Endpoint : default: http://100.100.100.200
+	Endpoint string
+	// RoleName : optional, discovered from the metadata service
+	RoleName string
+	Client   *http.Client
+	// RefreshBefore : optional, default: 15m, at least the longest ExpireSecond and presign Expires in use
+	RefreshBefore time.Duration
+	// RetryAfter : optional, default: 1m, a failed fetch is returned again until then, e.g. off ECS
+	RetryAfter time.Duration
+
+	cache    credentialsCache
+	failedAt time.Time
+	failure  error
+}
+
+func NewECSRAMRoleCredentialsProvider(roleName string) *ECSRAMRoleCredentialsProvider {
+	if roleName == "" {
+		roleName = os.Getenv("ALIBABA_CLOUD_ECS_METADATA")
+	}
+	return &ECSRAMRoleCredentialsProvider{
+		Endpoint: DefaultECSMetadataEndpoint,
+		RoleName: roleName,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (p *ECSRAMRoleCredentialsProvider) GetCredentials() (*Credentials, error) {
+	return p.cache.get(p.RefreshBefore, p.fetch)
+}
+
+// fetch : called with the cache locked, which guards failedAt and failure as well
+func (p *ECSRAMRoleCredentialsProvider) fetch() (*Credentials, error) {
+	retryAfter := p.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultECSMetadataRetryAfter
+	}
+	if p.failure != nil && time.Since(p.failedAt) < retryAfter {
+		return nil, p.failure
+	}
+	credentials, err := p.fetchMetadata()
+	if err != nil {
+		p.failedAt, p.failure = time.Now(), err
+		return nil, err
+	}
+	p.failure = nil
+	return credentials, nil
+}
+
+func (p *ECSRAMRoleCredentialsProvider) fetchMetadata() (*Credentials, error) {
+	// metadata token is best effort, instances in normal mode accept requests without it,
+	// but an unreachable metadata service means this is not an ECS instance
+	var metadataToken string
+	body, err := p.request(http.MethodPut, "/latest/api/token", "")
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return nil, fmt.Errorf("ecs ram role credentials: %w", err)
+	}
+	if err == nil {
+		metadataToken = string(body)
+	}
+
+	roleName := p.RoleName
+	if roleName == "" {
+		body, err := p.request(http.MethodGet, "/latest/meta-data/ram/security-credentials/", metadataToken)
+		if err != nil {
+			return nil, fmt.Errorf("ecs ram role credentials: %w", err)
+		}
+		roleName = strings.TrimSpace(string(body))
+	}
+
+	body, err = p.request(http.MethodGet, "/latest/meta-data/ram/security-credentials/"+url.PathEscape(roleName), metadataToken)
+	if err != nil {
+		return nil, fmt.Errorf("ecs ram role credentials: %w", err)
+	}
+	var result struct {
+		Code            string
+		AccessKeyId     string
+		AccessKeySecret string
+		SecurityToken   string
+		Expiration      time.Time
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("ecs ram role credentials: %w", err)
+	}
+	if result.Code != "Success" {
+		return nil, fmt.Errorf("ecs ram role credentials: unexpected code %q", result.Code)
+	}
+	return &Credentials{
+		AccessKeyId:     result.AccessKeyId,
+		AccessKeySecret: result.AccessKeySecret,
+		SecurityToken:   result.SecurityToken,
+		Expiration:      result.Expiration,
+	}, nil
+}
+
+func (p *ECSRAMRoleCredentialsProvider) request(method string, path string, metadataToken string) ([]byte, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultECSMetadataEndpoint
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(endpoint, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		req.Header.Set("X-aliyun-ecs-metadata-token-ttl-seconds", "21600")
+	} else if metadataToken != "" {
+		req.Header.Set("X-aliyun-ecs-metadata-token", metadataToken)
+	}
+	return doRequest(httpClient(p.Client), req)
+}
+
+// OIDCRoleCredentialsProvider : RRSA, assume a RAM role with the OIDC token mounted into the pod
+// https://help.aliyun.com/zh/ack/ack-managed-and-ack-dedicated/user-guide/use-rrsa-to-authorize-pods-to-access-different-cloud-services
+type OIDCRoleCredentialsProvider struct {
+	RoleArn         string
+	OIDCProviderArn string
+	OIDCTokenFile   string
+	RoleSessionName string
+	DurationSeconds int64
+	// Endpoint : default: https://sts.aliyuncs.com
+	Endpoint string
+	Client   *http.Client
+	// RefreshBefore : optional, default: 15m, at least the longest ExpireSecond and presign Expires in use
+	RefreshBefore time.Duration
+
+	cache credentialsCache
+}
+
+// NewOIDCRoleCredentialsProvider : configured by ALIBABA_CLOUD_ROLE_ARN, ALIBABA_CLOUD_OIDC_PROVIDER_ARN,
+// ALIBABA_CLOUD_OIDC_TOKEN_FILE and ALIBABA_CLOUD_ROLE_SESSION_NAME, as injected by RRSA
+func NewOIDCRoleCredentialsProvider() *OIDCRoleCredentialsProvider {
+	sessionName := os.Getenv("ALIBABA_CLOUD_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "aliyun-oss-appserver-go"
+	}
+	return &OIDCRoleCredentialsProvider{
+		RoleArn:         os.Getenv("ALIBABA_CLOUD_ROLE_ARN"),
+		OIDCProviderArn: os.Getenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN"),
+		OIDCTokenFile:   os.Getenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE"),
+		RoleSessionName: sessionName,
+		DurationSeconds: 3600,
+		Endpoint:        DefaultSTSEndpoint,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCRoleCredentialsProvider) GetCredentials() (*Credentials, error) {
+	return p.cache.get(p.RefreshBefore, p.fetch)
+}
+
+func (p *OIDCRoleCredentialsProvider) fetch() (*Credentials, error) {
+	if p.RoleArn == "" || p.OIDCProviderArn == "" || p.OIDCTokenFile == "" {
+		return nil, fmt.Errorf("oidc role credentials: missing required role arn, oidc provider arn or oidc token file")
+	}
+	oidcToken, err := os.ReadFile(p.OIDCTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("oidc role credentials: %w", err)
+	}
+
+	// AssumeRoleWithOIDC is an anonymous call, the OIDC token authenticates it
+	query := url.Values{}
+	query.Set("Action", "AssumeRoleWithOIDC")
+	query.Set("Format", "JSON")
+	query.Set("Version", "2015-04-01")
+	query.Set("Timestamp", time.Now().UTC().Format(TimeGMTISO8601))
+	form := url.Values{}
+	form.Set("RoleArn", p.RoleArn)
+	form.Set("OIDCProviderArn", p.OIDCProviderArn)
+	form.Set("OIDCToken", strings.TrimSpace(string(oidcToken)))
+	form.Set("RoleSessionName", p.RoleSessionName)
+	if p.DurationSeconds > 0 {
+		form.Set("DurationSeconds", strconv.FormatInt(p.DurationSeconds, 10))
+	}
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/?"+query.Encode(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	credentials, err := doSTSRequest(httpClient(p.Client), req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc role credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// doSTSRequest : parse the Credentials of an STS AssumeRole* response
+func doSTSRequest(client *http.Client, req *http.Request) (*Credentials, error) {
+	body, err := doRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyId     string
+			AccessKeySecret string
+			SecurityToken   string
+			Expiration      time.Time
+		}
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &Credentials{
+		AccessKeyId:     result.Credentials.AccessKeyId,
+		AccessKeySecret: result.Credentials.AccessKeySecret,
+		SecurityToken:   result.Credentials.SecurityToken,
+		Expiration:      result.Credentials.Expiration,
+	}, nil
+}
+
+// ChainCredentialsProvider : the first provider returning credentials wins and is remembered
+type ChainCredentialsProvider struct {
+	providers []CredentialsProvider
+
+	mu      sync.Mutex
+	current CredentialsProvider
+}
+
+func NewChainCredentialsProvider(providers ...CredentialsProvider) *ChainCredentialsProvider {
+	return &ChainCredentialsProvider{providers: providers}
+}
+
+// NewDefaultCredentialsProvider : environment variables, RRSA, shared credentials file, ECS RAM role
+func NewDefaultCredentialsProvider() *ChainCredentialsProvider {
+	providers := []CredentialsProvider{NewEnvironmentCredentialsProvider()}
+	if os.Getenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE") != "" {
+		providers = append(providers, NewOIDCRoleCredentialsProvider())
+	}
+	providers = append(providers,
+		NewProfileCredentialsProvider("", ""),
+		NewECSRAMRoleCredentialsProvider(""),
+	)
+	return NewChainCredentialsProvider(providers...)
+}
+
+// GetCredentials : the lock only guards current, the providers are asked without it
+func (p *ChainCredentialsProvider) GetCredentials() (*Credentials, error) {
+	p.mu.Lock()
+	current := p.current
+	p.mu.Unlock()
+
+	if current != nil {
+		if credentials, err := current.GetCredentials(); err == nil {
+			return credentials, nil
+		}
+	}
+	errs := make([]string, 0, len(p.providers))
+	for _, provider := range p.providers {
+		credentials, err := provider.GetCredentials()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		p.mu.Lock()
+		p.current = provider
+		p.mu.Unlock()
+		return credentials, nil
+	}
+	return nil, fmt.Errorf("no credentials found in chain: %s", strings.Join(errs, "; "))
+}
+
+func httpClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: unexpected status %d: %s", req.Method, req.URL.Path, resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,339 @@
+package appserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaticCredentialsProvider(t *testing.T) {
+	credentials, err := NewStaticCredentialsProvider("yourAccessKeyId", "yourAccessKeySecret", "").GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "yourAccessKeyId" || credentials.AccessKeySecret != "yourAccessKeySecret" {
+		t.Error("credentials error", credentials)
+	}
+	if _, err = NewStaticCredentialsProvider("", "", "").GetCredentials(); err == nil {
+		t.Error("empty credentials")
+	}
+}
+
+func TestEnvironmentCredentialsProvider(t *testing.T) {
+	t.Setenv("OSS_ACCESS_KEY_ID", "")
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "envAccessKeyId")
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "envAccessKeySecret")
+	t.Setenv("ALIBABA_CLOUD_SECURITY_TOKEN", "envSecurityToken")
+
+	credentials, err := NewEnvironmentCredentialsProvider().GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "envAccessKeyId" || credentials.SecurityToken != "envSecurityToken" {
+		t.Error("credentials error", credentials)
+	}
+
+	t.Setenv("OSS_ACCESS_KEY_ID", "ossAccessKeyId")
+	t.Setenv("OSS_ACCESS_KEY_SECRET", "ossAccessKeySecret")
+	credentials, err = NewEnvironmentCredentialsProvider().GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "ossAccessKeyId" || credentials.SecurityToken != "" {
+		t.Error("credentials error", credentials)
+	}
+}
+
+func TestProfileCredentialsProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	content := "[default]\ntype = access_key\naccess_key_id = defaultAccessKeyId\naccess_key_secret = defaultAccessKeySecret\n\n" +
+		"# temporary\n[upload]\ntype = sts\naccess_key_id = STS.uploadAccessKeyId\naccess_key_secret = uploadAccessKeySecret\nsecurity_token = uploadSecurityToken\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := NewProfileCredentialsProvider(path, "").GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "defaultAccessKeyId" || credentials.AccessKeySecret != "defaultAccessKeySecret" {
+		t.Error("credentials error", credentials)
+	}
+
+	t.Setenv("ALIBABA_CLOUD_CREDENTIALS_FILE", path)
+	credentials, err = NewProfileCredentialsProvider("", "upload").GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "STS.uploadAccessKeyId" || credentials.SecurityToken != "uploadSecurityToken" {
+		t.Error("credentials error", credentials)
+	}
+
+	if _, err = NewProfileCredentialsProvider(path, "missing").GetCredentials(); err == nil {
+		t.Error("missing profile")
+	}
+}
+
+func newECSMetadataServer(t *testing.T, expiration time.Time, fetched *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-aliyun-ecs-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("metadataToken"))
+	})
+	mux.HandleFunc("/latest/meta-data/ram/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aliyun-ecs-metadata-token") != "metadataToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/latest/meta-data/ram/security-credentials/" {
+			_, _ = w.Write([]byte("EcsRamRole"))
+			return
+		}
+		if r.URL.Path != "/latest/meta-data/ram/security-credentials/EcsRamRole" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := atomic.AddInt32(fetched, 1)
+		_, _ = fmt.Fprintf(w, `{"AccessKeyId":"STS.ecsAccessKeyId%d","AccessKeySecret":"ecsAccessKeySecret","SecurityToken":"ecsSecurityToken","Expiration":"%s","Code":"Success"}`,
+			n, expiration.UTC().Format(TimeGMTISO8601))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestECSRAMRoleCredentialsProvider(t *testing.T) {
+	t.Run("cached", func(t *testing.T) {
+		var fetched int32
+		server := newECSMetadataServer(t, time.Now().Add(time.Hour), &fetched)
+		provider := NewECSRAMRoleCredentialsProvider("")
+		provider.Endpoint = server.URL
+
+		for i := 0; i < 3; i++ {
+			credentials, err := provider.GetCredentials()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credentials.AccessKeyId != "STS.ecsAccessKeyId1" || credentials.SecurityToken != "ecsSecurityToken" {
+				t.Error("credentials error", credentials)
+			}
+		}
+		if fetched != 1 {
+			t.Errorf("expect 1 fetch, got %d", fetched)
+		}
+	})
+
+	t.Run("refresh before expiration", func(t *testing.T) {
+		var fetched int32
+		server := newECSMetadataServer(t, time.Now().Add(time.Minute), &fetched)
+		provider := NewECSRAMRoleCredentialsProvider("EcsRamRole")
+		provider.Endpoint = server.URL
+
+		for i := 0; i < 2; i++ {
+			if _, err := provider.GetCredentials(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if fetched != 2 {
+			t.Errorf("expect 2 fetches, got %d", fetched)
+		}
+	})
+
+	t.Run("off ecs", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		transport := &countingTransport{}
+		provider := NewECSRAMRoleCredentialsProvider("")
+		provider.Endpoint = server.URL
+		provider.Client = &http.Client{Transport: transport}
+
+		// the unreachable metadata service is probed once, not once per token
+		for i := 0; i < 3; i++ {
+			if _, err := provider.GetCredentials(); err == nil {
+				t.Fatal("off ecs")
+			}
+		}
+		if transport.requests != 1 {
+			t.Errorf("expect 1 request, got %d", transport.requests)
+		}
+		provider.Client = nil
+		provider.failedAt = time.Now().Add(-DefaultECSMetadataRetryAfter)
+		var fetched int32
+		provider.Endpoint = newECSMetadataServer(t, time.Now().Add(time.Hour), &fetched).URL
+		if _, err := provider.GetCredentials(); err != nil || fetched != 1 {
+			t.Error("retry after failure", err, fetched)
+		}
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		var fetched int32
+		server := newECSMetadataServer(t, time.Now().Add(time.Hour), &fetched)
+		provider := NewECSRAMRoleCredentialsProvider("UnknownRole")
+		provider.Endpoint = server.URL
+		if _, err := provider.GetCredentials(); err == nil {
+			t.Error("unknown role")
+		}
+	})
+}
+
+func TestOIDCRoleCredentialsProvider(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("oidcToken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("Action") != "AssumeRoleWithOIDC" || r.FormValue("OIDCToken") != "oidcToken" ||
+			r.FormValue("RoleArn") != "acs:ram::123456:role/upload" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"Code":"InvalidParameter"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"RequestId":"1","Credentials":{"AccessKeyId":"STS.oidcAccessKeyId","AccessKeySecret":"oidcAccessKeySecret","SecurityToken":"oidcSecurityToken","Expiration":"%s"}}`,
+			time.Now().Add(time.Hour).UTC().Format(TimeGMTISO8601))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("ALIBABA_CLOUD_ROLE_ARN", "acs:ram::123456:role/upload")
+	t.Setenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN", "acs:ram::123456:oidc-provider/ack-rrsa")
+	t.Setenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE", tokenFile)
+	provider := NewOIDCRoleCredentialsProvider()
+	provider.Endpoint = server.URL
+
+	credentials, err := provider.GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "STS.oidcAccessKeyId" || credentials.SecurityToken != "oidcSecurityToken" {
+		t.Error("credentials error", credentials)
+	}
+
+	provider = NewOIDCRoleCredentialsProvider()
+	provider.Endpoint = server.URL
+	provider.RoleArn = "acs:ram::123456:role/other"
+	if _, err = provider.GetCredentials(); err == nil {
+		t.Error("sts error")
+	}
+}
+
+func TestChainCredentialsProvider(t *testing.T) {
+	t.Setenv("OSS_ACCESS_KEY_ID", "")
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "")
+
+	provider := NewChainCredentialsProvider(
+		NewEnvironmentCredentialsProvider(),
+		NewProfileCredentialsProvider(filepath.Join(t.TempDir(), "missing"), ""),
+		NewStaticCredentialsProvider("yourAccessKeyId", "yourAccessKeySecret", ""),
+	)
+	credentials, err := provider.GetCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "yourAccessKeyId" {
+		t.Error("credentials error", credentials)
+	}
+
+	if _, err = NewChainCredentialsProvider(NewEnvironmentCredentialsProvider()).GetCredentials(); err == nil {
+		t.Error("empty chain")
+	}
+}
+
+func TestTokenCredentialsProvider(t *testing.T) {
+	var calls int32
+	token := NewToken(&Config{
+		Host: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		CredentialsProvider: CredentialsProviderFunc(func() (*Credentials, error) {
+			n := atomic.AddInt32(&calls, 1)
+			return &Credentials{
+				AccessKeyId:     fmt.Sprintf("STS.accessKeyId%d", n),
+				AccessKeySecret: "accessKeySecret",
+				SecurityToken:   "securityToken",
+				Expiration:      time.Now().Add(time.Hour),
+			}, nil
+		}),
+	})
+
+	for i := 1; i <= 2; i++ {
+		tokenPayload, err := token.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokenPayload.OSSAccessKeyId != fmt.Sprintf("STS.accessKeyId%d", i) || tokenPayload.SecurityToken != "securityToken" {
+			t.Error("token error", tokenPayload)
+		}
+	}
+}
+
+func TestCachedCredentialsProviderPolicyWindow(t *testing.T) {
+	var calls int32
+	provider := NewCachedCredentialsProvider(CredentialsProviderFunc(func() (*Credentials, error) {
+		atomic.AddInt32(&calls, 1)
+		return &Credentials{
+			AccessKeyId:     "STS.accessKeyId2",
+			AccessKeySecret: "accessKeySecret",
+			SecurityToken:   "securityToken",
+			Expiration:      time.Now().Add(2 * time.Hour),
+		}, nil
+	}))
+	// cached credentials expiring within the 600s policy must be refreshed, not outlived
+	provider.cache.credentials = &Credentials{
+		AccessKeyId:     "STS.accessKeyId1",
+		AccessKeySecret: "accessKeySecret",
+		SecurityToken:   "securityToken",
+		Expiration:      time.Now().Add(8 * time.Minute),
+	}
+	token := NewToken(&Config{Host: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com", CredentialsProvider: provider})
+	tokenPayload, err := token.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenPayload.OSSAccessKeyId != "STS.accessKeyId2" || calls != 1 {
+		t.Error("credentials must be refreshed", tokenPayload.OSSAccessKeyId, calls)
+	}
+
+	// a longer policy needs a longer refresh margin
+	provider.cache.credentials.Expiration = time.Now().Add(50 * time.Minute)
+	provider.RefreshBefore = time.Hour
+	token = NewToken(&Config{Host: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com", CredentialsProvider: provider, ExpireSecond: 3600})
+	if _, err = token.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expect 2 fetches, got %d", calls)
+	}
+}
+
+func TestChainCredentialsProviderConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	slow := CredentialsProviderFunc(func() (*Credentials, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		return nil, fmt.Errorf("no credentials")
+	})
+	provider := NewChainCredentialsProvider(slow, NewStaticCredentialsProvider("yourAccessKeyId", "yourAccessKeySecret", ""))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = provider.GetCredentials()
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the first call is stuck in the slow provider, the chain must not be locked meanwhile
+	if _, err := provider.GetCredentials(); err != nil {
+		t.Error(err)
+	}
+	close(release)
+	<-done
+}
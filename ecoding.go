@@ -29,7 +29,6 @@ const (
 // unescapePath : unescapes a string; the mode specifies, which section of the URL string is being unescaped.
 func unescapePath(s string, mode encoding) (string, error) {
 	// Count %, check that they're well-formed.
-	mode = encodePathSegment
 	n := 0
 	hasPlus := false
 	for i := 0; i < len(s); {
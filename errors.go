@@ -0,0 +1,113 @@
+package appserver
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Failure kinds, match them with errors.Is; the typed errors below carry the details for errors.As
+var (
+	ErrMissingHeader         = errors.New("missing header")
+	ErrInvalidBase64         = errors.New("invalid base64")
+	ErrPublicKeyFetch        = errors.New("public key fetch failed")
+	ErrInvalidPEM            = errors.New("invalid public key pem")
+	ErrNotRSAPublicKey       = errors.New("public key is not rsa")
+	ErrSignatureMismatch     = errors.New("callback signature mismatch")
+	ErrCallbackBodyDecode    = errors.New("invalid callback body")
+	ErrInvalidConfig         = errors.New("invalid config")
+	ErrInvalidKey            = errors.New("invalid key")
+	ErrOutlivesSecurityToken = errors.New("expiration outlives security token")
+)
+
+// Public key fetch failures, wrapped in a *PublicKeyFetchError
+var (
+	ErrPublicKeyURLNotAllowed = errors.New("public key url not allowed")
+	ErrPublicKeyRedirect      = errors.New("public key url redirected")
+	ErrPublicKeyTooLarge      = errors.New("public key too large")
+	ErrPublicKeyFetchTimeout  = errors.New("public key fetch timed out")
+	ErrPublicKeyNotPinned     = errors.New("public key not pinned")
+)
+
+// Callback request checks, all run before any public key is fetched
+var (
+	ErrCallbackMethodNotAllowed = errors.New("callback method not allowed")
+	ErrCallbackBodyTooLarge     = errors.New("callback body too large")
+	ErrCallbackContentType      = errors.New("unsupported callback content type")
+	ErrMissingAuthorization     = error(&HeaderError{Header: AuthorizationHeader, Err: ErrMissingHeader})
+	ErrMissingPublicKeyURL      = error(&HeaderError{Header: PubKeyUrlHeader, Err: ErrMissingHeader})
+)
+
+// HeaderError : a missing or undecodable callback header, errors.Is(err, ErrMissingHeader) or ErrInvalidBase64
+type HeaderError struct {
+	Header string
+	Err    error
+}
+
+func (e *HeaderError) Error() string {
+	if e.Err == ErrMissingHeader {
+		return "missing " + e.Header + " header"
+	}
+	return "invalid " + e.Header + " header: " + e.Err.Error()
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// PublicKeyFetchError : the callback public key could not be fetched, errors.Is(err, ErrPublicKeyFetch)
+type PublicKeyFetchError struct {
+	URL string
+	Err error
+}
+
+func (e *PublicKeyFetchError) Error() string {
+	return "get public key " + e.URL + ": " + e.Err.Error()
+}
+
+func (e *PublicKeyFetchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PublicKeyFetchError) Is(target error) bool {
+	return target == ErrPublicKeyFetch
+}
+
+// ConfigError : an invalid Config or Callback field, errors.Is(err, ErrInvalidConfig)
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+func configErrorf(field string, format string, args ...any) error {
+	return &ConfigError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// kindError : errors.Is matches the kind as well as the cause, errors.As reaches the cause
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
@@ -0,0 +1,116 @@
+package appserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	var headerError *HeaderError
+	_, err := GetAuthorization("")
+	if !errors.Is(err, ErrMissingHeader) || !errors.Is(err, ErrMissingAuthorization) || !errors.As(err, &headerError) || headerError.Header != AuthorizationHeader {
+		t.Error("missing header error", err)
+	}
+	var corruptInputError base64.CorruptInputError
+	_, err = GetAuthorization("not base64")
+	if !errors.Is(err, ErrInvalidBase64) || !errors.As(err, &corruptInputError) || err.Error() != "invalid Authorization header: invalid base64: illegal base64 data at input byte 3" {
+		t.Error("invalid base64 error", err)
+	}
+
+	if _, err = ParsePublicKey([]byte("not a pem")); !errors.Is(err, ErrInvalidPEM) {
+		t.Error("invalid pem error", err)
+	}
+	if _, err = ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("not der")})); !errors.Is(err, ErrInvalidPEM) {
+		t.Error("invalid pem error", err)
+	}
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+	if _, err = ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); !errors.Is(err, ErrNotRSAPublicKey) {
+		t.Error("not rsa error", err)
+	}
+
+	publicKey, _ := ParsePublicKey(newTestPublicKeyPEM(t))
+	if err = verifySignature(publicKey, make([]byte, 16), make([]byte, 128)); !errors.Is(err, ErrSignatureMismatch) || !errors.Is(err, rsa.ErrVerification) {
+		t.Error("signature mismatch error", err)
+	}
+
+	var syntaxError *json.SyntaxError
+	if _, err = DecodeCallbackBody(CallbackBodyTypeParam, []byte("{")); !errors.Is(err, ErrCallbackBodyDecode) || !errors.As(err, &syntaxError) {
+		t.Error("body decode error", err)
+	}
+
+	var configError *ConfigError
+	if err = (&Config{}).Validate(); !errors.Is(err, ErrInvalidConfig) || !errors.As(err, &configError) || configError.Field != "access_key_id" {
+		t.Error("invalid config error", err)
+	}
+	if err = (&Callback{CallbackUrl: "ftp://example.com", CallbackBody: "bucket=${bucket}"}).Validate(); !errors.As(err, &configError) || configError.Field != "CallbackUrl" {
+		t.Error("invalid callback error", err)
+	}
+	token := NewToken(&Config{AccessKeyId: "yourAccessKeyId", AccessKeySecret: "yourAccessKeySecret", Host: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com"})
+	keyTemplateToken := NewToken(&Config{AccessKeyId: "yourAccessKeyId", AccessKeySecret: "yourAccessKeySecret", Host: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com", KeyTemplate: "${date}"})
+	if _, err = keyTemplateToken.Generate(); !errors.As(err, &configError) || configError.Field != "key_template" || err.Error() != `key template "${date}": placeholder ${date} requires a layout, e.g. ${date:2006/01/02}` {
+		t.Error("invalid key template error", err)
+	}
+	for field, err := range map[string]error{
+		"access_key_id":     (&Credentials{}).Validate(),
+		"role_arn":          (&AssumeRoleRequest{RoleSessionName: "user"}).Validate(),
+		"role_session_name": (&AssumeRoleRequest{RoleArn: "acs:ram::123456:role/upload"}).Validate(),
+		"bucket":            errorOf(NewDirectorySessionPolicy("", "user-dir/")),
+		"directory":         errorOf(NewDirectorySessionPolicy("bucket-name", "user-*/")),
+		"host":              errorOf(NewPresigner(&Config{}).PresignGetObject("image.jpg", nil)),
+		"region":            errorOf(NewPresigner(&Config{Host: "https://static.example.com", Bucket: "bucket-name", AccessKeyId: "yourAccessKeyId", AccessKeySecret: "yourAccessKeySecret", SignatureVersion: SignatureVersionV4}).PresignGetObject("image.jpg", nil)),
+	} {
+		if !errors.Is(err, ErrInvalidConfig) || !errors.As(err, &configError) || configError.Field != field {
+			t.Errorf("%s: invalid config error %v", field, err)
+		}
+	}
+	if _, err = token.SetCallbackVars(map[string]string{"x:User": "1"}).Generate(); !errors.As(err, &configError) || configError.Field != "CallbackVars" {
+		t.Error("callback vars without a callback error", err)
+	}
+	if _, err = token.SetCallback(&Callback{CallbackUrl: "https://example.com", CallbackBody: "bucket=${bucket}"}).SetCallbackVars(map[string]string{"x:User": "1"}).Generate(); !errors.Is(err, ErrInvalidConfig) || err.Error() != `invalid character 'U' in callback var "x:User", must be lower-case` {
+		t.Error("invalid callback var error", err)
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	var fetchError *PublicKeyFetchError
+	var verifier CallbackVerifier
+	_, err = verifier.getPublicKey(context.Background(), server.URL+"/key.pem")
+	if !errors.Is(err, ErrPublicKeyFetch) || !errors.As(err, &fetchError) || fetchError.URL != server.URL+"/key.pem" {
+		t.Error("key fetch error", err)
+	}
+}
+
+func TestCallbackHTTPError(t *testing.T) {
+	for expect, err := range map[int]error{
+		http.StatusMethodNotAllowed:      ErrCallbackMethodNotAllowed,
+		http.StatusRequestEntityTooLarge: ErrCallbackBodyTooLarge,
+		http.StatusUnsupportedMediaType:  ErrCallbackContentType,
+		http.StatusBadRequest:            &HeaderError{Header: PubKeyUrlHeader, Err: &kindError{kind: ErrInvalidBase64, err: base64.CorruptInputError(0)}},
+		http.StatusForbidden:             &kindError{kind: ErrSignatureMismatch, err: rsa.ErrVerification},
+		http.StatusGatewayTimeout:        &PublicKeyFetchError{URL: testPubKeyURL, Err: ErrPublicKeyFetchTimeout},
+		http.StatusBadGateway:            &PublicKeyFetchError{URL: testPubKeyURL, Err: errors.New("unexpected status 404")},
+	} {
+		if httpError := callbackHTTPError(err); httpError.Status != expect {
+			t.Errorf("%v: expect %d, got %d", err, expect, httpError.Status)
+		}
+	}
+	if httpError := callbackHTTPError(ErrNotRSAPublicKey); httpError.Message != "failed to get callback public key" {
+		t.Error("upstream details must not be exposed", httpError.Message)
+	}
+}
+
+func errorOf[T any](_ T, err error) error {
+	return err
+}
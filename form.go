@@ -0,0 +1,94 @@
+package appserver
+
+// FormFileField : the file field, OSS ignores the form fields after it so it must be the last one
+const FormFileField = "file"
+
+// FormFilenameKey : OSS replaces ${filename} in the key field with the name of the uploaded file
+const FormFilenameKey = "${filename}"
+
+// FormField : a PostObject multipart form field
+type FormField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// FormFields : the PostObject form fields in order, FormFileField goes after them
+// https://help.aliyun.com/zh/oss/developer-reference/postobject
+func (s *SignatureToken) FormFields() []FormField {
+	key := s.Key
+	if key == "" {
+		key = s.Directory + FormFilenameKey
+	}
+	fields := []FormField{
+		{Name: "key", Value: key},
+		{Name: "policy", Value: s.Policy},
+	}
+	if s.SignatureVersion != "" {
+		fields = append(fields,
+			FormField{Name: "x-oss-signature-version", Value: s.SignatureVersion},
+			FormField{Name: "x-oss-credential", Value: s.Credential},
+			FormField{Name: "x-oss-date", Value: s.Date},
+			FormField{Name: "x-oss-signature", Value: s.SignatureV4},
+		)
+	} else {
+		fields = append(fields,
+			FormField{Name: "OSSAccessKeyId", Value: s.OSSAccessKeyId},
+			FormField{Name: "Signature", Value: s.Signature},
+		)
+	}
+	if s.SecurityToken != "" {
+		fields = append(fields, FormField{Name: "x-oss-security-token", Value: s.SecurityToken})
+	}
+	if s.ContentType != "" {
+		fields = append(fields, FormField{Name: "Content-Type", Value: s.ContentType})
+	}
+	if s.Callback != "" {
+		fields = append(fields, FormField{Name: "callback", Value: s.Callback})
+	}
+	// custom callback vars, e.g. x:user_id, the PostObject form of callback-var
+	for _, name := range sortedCallbackVarNames(s.CallbackVars) {
+		fields = append(fields, FormField{Name: name, Value: s.CallbackVars[name]})
+	}
+	return fields
+}
+
+func (s *SignatureToken) formFieldMap() map[string]string {
+	fields := s.FormFields()
+	m := make(map[string]string, len(fields))
+	for _, field := range fields {
+		m[field.Name] = field.Value
+	}
+	return m
+}
+
+// UppyParams : the upload parameters of Uppy AwsS3 getUploadParameters, and of other {url, fields} widgets
+// https://uppy.io/docs/aws-s3/#getuploadparametersfile-options
+type UppyParams struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (s *SignatureToken) UppyParams() *UppyParams {
+	return &UppyParams{
+		Method: "POST",
+		URL:    s.Host,
+		Fields: s.formFieldMap(),
+	}
+}
+
+// PluploadParams : the Plupload uploader options
+// https://www.plupload.com/docs/v2/Options
+type PluploadParams struct {
+	URL             string            `json:"url"`
+	MultipartParams map[string]string `json:"multipart_params"`
+	FileDataName    string            `json:"file_data_name"`
+}
+
+func (s *SignatureToken) PluploadParams() *PluploadParams {
+	return &PluploadParams{
+		URL:             s.Host,
+		MultipartParams: s.formFieldMap(),
+		FileDataName:    FormFileField,
+	}
+}
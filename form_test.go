@@ -0,0 +1,100 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignatureTokenFormFields(t *testing.T) {
+	v1 := &SignatureToken{
+		OSSAccessKeyId: "yourAccessKeyId",
+		Policy:         "eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoiLCJjb25kaXRpb25zIjpbeyJ4LW9zcy1zZWN1cml0eS10b2tlbiI6InlvdXJTZWN1cml0eVRva2VuIn1dfQ==",
+		Signature:      "GiqKdMSoCYi+of7dLUIPCf5f4AI=",
+		SecurityToken:  "yourSecurityToken",
+		Host:           "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		Expire:         1735689600,
+		Directory:      "user-dir-prefix/",
+	}
+	v4 := &SignatureToken{
+		Policy:           "eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoifQ==",
+		Callback:         "eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayJ9",
+		CallbackVars:     map[string]string{"x:user_id": "123", "x:album": "travel"},
+		SignatureVersion: SignatureAlgorithmV4,
+		Credential:       "yourAccessKeyId/20241231/cn-hangzhou/oss/aliyun_v4_request",
+		Date:             "20241231T235000Z",
+		SignatureV4:      "ed3d8438961723db1f6a18b019d948be84b02e7560bdb81f202a444a4851e551",
+		Host:             "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		Expire:           1735689600,
+		Directory:        "user-dir-prefix/",
+		Key:              "user-dir-prefix/image.jpg",
+	}
+
+	for name, c := range map[string]struct {
+		token  *SignatureToken
+		expect string
+	}{
+		"v1": {v1, `[{"name":"key","value":"user-dir-prefix/${filename}"},{"name":"policy","value":"eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoiLCJjb25kaXRpb25zIjpbeyJ4LW9zcy1zZWN1cml0eS10b2tlbiI6InlvdXJTZWN1cml0eVRva2VuIn1dfQ=="},{"name":"OSSAccessKeyId","value":"yourAccessKeyId"},{"name":"Signature","value":"GiqKdMSoCYi+of7dLUIPCf5f4AI="},{"name":"x-oss-security-token","value":"yourSecurityToken"}]`},
+		"v4": {v4, `[{"name":"key","value":"user-dir-prefix/image.jpg"},{"name":"policy","value":"eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoifQ=="},{"name":"x-oss-signature-version","value":"OSS4-HMAC-SHA256"},{"name":"x-oss-credential","value":"yourAccessKeyId/20241231/cn-hangzhou/oss/aliyun_v4_request"},{"name":"x-oss-date","value":"20241231T235000Z"},{"name":"x-oss-signature","value":"ed3d8438961723db1f6a18b019d948be84b02e7560bdb81f202a444a4851e551"},{"name":"callback","value":"eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayJ9"},{"name":"x:album","value":"travel"},{"name":"x:user_id","value":"123"}]`},
+	} {
+		fieldsJson, _ := json.Marshal(c.token.FormFields())
+		if string(fieldsJson) != c.expect {
+			t.Errorf("%s: expect %s, got %s", name, c.expect, fieldsJson)
+		}
+	}
+
+	uppyJson, _ := json.Marshal(v1.UppyParams())
+	expectUppy := `{"method":"POST","url":"https://bucket-name.oss-cn-hangzhou.aliyuncs.com","fields":{"OSSAccessKeyId":"yourAccessKeyId","Signature":"GiqKdMSoCYi+of7dLUIPCf5f4AI=","key":"user-dir-prefix/${filename}","policy":"eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoiLCJjb25kaXRpb25zIjpbeyJ4LW9zcy1zZWN1cml0eS10b2tlbiI6InlvdXJTZWN1cml0eVRva2VuIn1dfQ==","x-oss-security-token":"yourSecurityToken"}}`
+	if string(uppyJson) != expectUppy {
+		t.Errorf("expect %s, got %s", expectUppy, uppyJson)
+	}
+
+	pluploadJson, _ := json.Marshal(v4.PluploadParams())
+	expectPlupload := `{"url":"https://bucket-name.oss-cn-hangzhou.aliyuncs.com","multipart_params":{"callback":"eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayJ9","key":"user-dir-prefix/image.jpg","policy":"eyJleHBpcmF0aW9uIjoiMjAyNS0wMS0wMVQwMDowMDowMFoifQ==","x-oss-credential":"yourAccessKeyId/20241231/cn-hangzhou/oss/aliyun_v4_request","x-oss-date":"20241231T235000Z","x-oss-signature":"ed3d8438961723db1f6a18b019d948be84b02e7560bdb81f202a444a4851e551","x-oss-signature-version":"OSS4-HMAC-SHA256","x:album":"travel","x:user_id":"123"},"file_data_name":"file"}`
+	if string(pluploadJson) != expectPlupload {
+		t.Errorf("expect %s, got %s", expectPlupload, pluploadJson)
+	}
+}
+
+// TestFormFieldsSatisfyPolicy : the form built from the token passes its own policy
+func TestFormFieldsSatisfyPolicy(t *testing.T) {
+	at := time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC)
+
+	handler := newTestTokenHandler()
+	handler.Customize = func(r *http.Request, user string, token *Token) (*Token, error) {
+		return token.SetCallback(&Callback{CallbackUrl: "http://domain.com/oss/callback", CallbackBody: "object=${object}"}).
+			SetCallbackVars(map[string]string{"user_id": user}), nil
+	}
+	w := serveTokenHandler(handler, "GET", "/oss/token?filename=image.jpg&content_type=image%2Fjpeg&size=512&format=fields", "")
+	var fields struct {
+		Fields []FormField `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err, w.Body)
+	}
+	form := make(map[string]string)
+	for _, field := range fields.Fields {
+		form[field.Name] = field.Value
+	}
+	if form["Content-Type"] != "image/jpeg" || form["x:user_id"] != "user-1" {
+		t.Error("form fields error", form)
+	}
+	if violations, err := EvaluatePolicy(form["policy"], form, 512, at); err != nil || len(violations) != 0 {
+		t.Errorf("handler: unexpected violations %v %v", violations, err)
+	}
+
+	tokens, err := newTestBatchToken(&Config{ContentTypes: []string{"image/*"}}).GenerateBatch([]FileDescriptor{
+		{Name: "image.jpg", Size: 2788, ContentType: "image/jpeg"},
+		{Name: "image.png", Size: 1024, ContentType: "image/png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, size := range []int64{2788, 1024} {
+		form := tokens[i].formFieldMap()
+		if violations, err := EvaluatePolicy(tokens[i].Policy, form, size, at); err != nil || len(violations) != 0 {
+			t.Errorf("batch %d: unexpected violations %v %v", i, violations, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package appserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxObjectKeyLength = 1023
+const DefaultSha256PrefixLength = 8
+
+// KeyInput : what a key is generated from, usually taken from the upload request
+type KeyInput struct {
+	Filename string    `json:"filename"` // original file name, only its extension is used by templates
+	User     string    `json:"user"`
+	Time     time.Time `json:"-"` // optional, default: now
+}
+
+// KeyGenerator : object key relative to the policy directory
+type KeyGenerator interface {
+	GenerateKey(input *KeyInput) (string, error)
+}
+
+// KeyGeneratorFunc : adapter to use an ordinary function as a KeyGenerator
+type KeyGeneratorFunc func(input *KeyInput) (string, error)
+
+func (f KeyGeneratorFunc) GenerateKey(input *KeyInput) (string, error) {
+	return f(input)
+}
+
+// KeyTemplate : object key template with placeholders
+//
+//	${uuid}               random uuid v4
+//	${date:2006/01/02}    upload time in UTC, formatted with a Go layout
+//	${user}               KeyInput.User, letters, digits and ._@- only
+//	${ext}                lower-case extension of KeyInput.Filename with the dot, empty when missing
+//	${sha256-prefix}      first 8 (or ${sha256-prefix:N}) hex chars of sha256(user/filename/uuid), spreads keys
+type KeyTemplate struct {
+	template string
+	parts    []keyTemplatePart
+}
+
+type keyTemplatePart struct {
+	literal string
+	name    string
+	arg     string
+}
+
+func NewKeyTemplate(template string) (*KeyTemplate, error) {
+	if template == "" {
+		return nil, configErrorf("key_template", "missing required key template")
+	}
+	kt := &KeyTemplate{template: template}
+	rest := template
+	for rest != "" {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			kt.parts = append(kt.parts, keyTemplatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			kt.parts = append(kt.parts, keyTemplatePart{literal: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, configErrorf("key_template", "unclosed placeholder in key template %q", template)
+		}
+		name, arg, _ := strings.Cut(rest[start+2:start+end], ":")
+		part := keyTemplatePart{name: name, arg: arg}
+		if err := part.validate(); err != nil {
+			return nil, configErrorf("key_template", "key template %q: %w", template, err)
+		}
+		kt.parts = append(kt.parts, part)
+		rest = rest[start+end+1:]
+	}
+	return kt, nil
+}
+
+func (p keyTemplatePart) validate() error {
+	switch p.name {
+	case "uuid", "user", "ext":
+		if p.arg != "" {
+			return fmt.Errorf("placeholder ${%s} takes no argument", p.name)
+		}
+	case "date":
+		if p.arg == "" {
+			return fmt.Errorf("placeholder ${date} requires a layout, e.g. ${date:2006/01/02}")
+		}
+	case "sha256-prefix":
+		if p.arg != "" {
+			n, err := strconv.Atoi(p.arg)
+			if err != nil || n < 1 || n > sha256.Size*2 {
+				return fmt.Errorf("invalid ${sha256-prefix} length %q", p.arg)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported placeholder ${%s}", p.name)
+	}
+	return nil
+}
+
+func (kt *KeyTemplate) String() string {
+	return kt.template
+}
+
+func (kt *KeyTemplate) GenerateKey(input *KeyInput) (string, error) {
+	if input == nil {
+		input = new(KeyInput)
+	}
+	uuid, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	at := input.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	var b strings.Builder
+	for _, part := range kt.parts {
+		switch part.name {
+		case "":
+			b.WriteString(part.literal)
+		case "uuid":
+			b.WriteString(uuid)
+		case "date":
+			b.WriteString(at.UTC().Format(part.arg))
+		case "user":
+			if err = validateKeyUser(input.User); err != nil {
+				return "", err
+			}
+			b.WriteString(input.User)
+		case "ext":
+			b.WriteString(keyExt(input.Filename))
+		case "sha256-prefix":
+			n := DefaultSha256PrefixLength
+			if part.arg != "" {
+				n, _ = strconv.Atoi(part.arg)
+			}
+			sum := sha256.Sum256([]byte(input.User + "/" + input.Filename + "/" + uuid))
+			b.WriteString(hex.EncodeToString(sum[:])[:n])
+		}
+	}
+	return b.String(), nil
+}
+
+func validateKeyUser(user string) error {
+	if user == "" || user == "." || user == ".." {
+		return fmt.Errorf("%w: user %q", ErrInvalidKey, user)
+	}
+	for _, c := range user {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("._@-", c)) {
+			return fmt.Errorf("%w: invalid character %q in user %q", ErrInvalidKey, c, user)
+		}
+	}
+	return nil
+}
+
+// keyExt : ".jpg" from "Photo.JPG", odd extensions are dropped
+func keyExt(filename string) string {
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(filename, "\\", "/")))
+	if len(ext) < 2 || len(ext) > 16 {
+		return ""
+	}
+	for _, c := range ext[1:] {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// ValidateObjectKey
+// https://help.aliyun.com/zh/oss/user-guide/object-naming-conventions
+func ValidateObjectKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: missing required object key", ErrInvalidKey)
+	}
+	if len(key) > MaxObjectKeyLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidKey, MaxObjectKeyLength)
+	}
+	if key[0] == '/' || key[0] == '\\' {
+		return fmt.Errorf("%w: %q must not start with / or \\", ErrInvalidKey, key)
+	}
+	for _, c := range key {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("%w: control character in %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
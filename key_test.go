@@ -0,0 +1,99 @@
+package appserver
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for template, pattern := range map[string]string{
+		"${uuid}${ext}":                    `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.jpg$`,
+		"${date:2006/01/02}/${user}${ext}": `^2025/01/02/user-1\.jpg$`,
+		"${sha256-prefix}/${user}":         `^[0-9a-f]{8}/user-1$`,
+		"${sha256-prefix:2}/a.bin":         `^[0-9a-f]{2}/a\.bin$`,
+		"static/avatar":                    `^static/avatar$`,
+	} {
+		kt, err := NewKeyTemplate(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := kt.GenerateKey(&KeyInput{Filename: "Photo.JPG", User: "user-1", Time: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(pattern).MatchString(key) {
+			t.Errorf("%s: key %s does not match %s", template, key, pattern)
+		}
+	}
+}
+
+func TestKeyTemplateInvalid(t *testing.T) {
+	for _, template := range []string{"", "${filename}", "${date}", "${uuid", "${uuid:4}", "${sha256-prefix:0}", "${sha256-prefix:65}"} {
+		if _, err := NewKeyTemplate(template); err == nil {
+			t.Errorf("%q: expect error", template)
+		}
+	}
+
+	kt, _ := NewKeyTemplate("${user}/${uuid}")
+	for _, user := range []string{"", "..", "a/b", "a b"} {
+		if _, err := kt.GenerateKey(&KeyInput{User: user}); err == nil {
+			t.Errorf("user %q: expect error", user)
+		}
+	}
+
+	for filename, ext := range map[string]string{"a.PNG": ".png", "a": "", "a.tar.gz": ".gz", "a.j p g": "", "dir.d\\file": ""} {
+		if got := keyExt(filename); got != ext {
+			t.Errorf("%s: expect %q, got %q", filename, ext, got)
+		}
+	}
+}
+
+func TestTokenKeyGenerate(t *testing.T) {
+	token := NewToken(&Config{
+		AccessKeyId:     "yourAccessKeyId",
+		AccessKeySecret: "yourAccessKeySecret",
+		Host:            "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		Directory:       "user-dir-prefix/",
+		KeyTemplate:     "${date:2006/01/02}/${user}${ext}",
+	})
+	token.now = func() time.Time {
+		return time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	}
+
+	tokenPayload, err := token.SetKeyInput(&KeyInput{Filename: "image.jpg", User: "user-1"}).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenPayload.Key != "user-dir-prefix/2024/12/31/user-1.jpg" {
+		t.Errorf("key error, got %s", tokenPayload.Key)
+	}
+
+	signedAt := time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC)
+	violations, err := EvaluatePolicy(tokenPayload.Policy, map[string]string{"key": tokenPayload.Key}, 0, signedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Error("unexpected violations", violations)
+	}
+	violations, _ = EvaluatePolicy(tokenPayload.Policy, map[string]string{"key": "user-dir-prefix/other.jpg"}, 0, signedAt)
+	if len(violations) != 1 || violations[0].Operator != PolicyConditionEq {
+		t.Error("key not pinned", violations)
+	}
+
+	tokenPayload, err = token.SetKeyGenerator(KeyGeneratorFunc(func(input *KeyInput) (string, error) {
+		return "custom/" + input.Filename, nil
+	})).SetKeyInput(&KeyInput{Filename: "a.txt"}).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenPayload.Key != "user-dir-prefix/custom/a.txt" {
+		t.Errorf("key error, got %s", tokenPayload.Key)
+	}
+
+	if _, err = token.SetKeyInput(&KeyInput{Filename: "image.jpg"}).Generate(); err == nil {
+		t.Error("missing user")
+	}
+}
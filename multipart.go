@@ -0,0 +1,188 @@
+package appserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxMultipartParts = 10000
+const CompleteMultipartUploadContentType = "application/xml"
+
+// MultipartOptions
+// https://help.aliyun.com/zh/oss/developer-reference/initiatemultipartupload
+type MultipartOptions struct {
+	Expires      time.Duration     // optional, default: Config.ExpireSecond, for every url of the upload
+	ContentType  string            // optional, signed on InitiateMultipartUpload, Content-Type of the object
+	Headers      map[string]string // optional, signed x-oss-* headers on InitiateMultipartUpload, e.g. x-oss-object-acl
+	CallbackVars map[string]string // optional, "user_id" or "x:user_id", wired into the CompleteMultipartUpload callback body
+}
+
+// MultipartUpload : the client PUTs part i to Parts[i-1], then POSTs the CompleteMultipartUpload xml to Complete
+type MultipartUpload struct {
+	Key      string              `json:"key"`
+	UploadId string              `json:"upload_id"`
+	Parts    []*PresignedRequest `json:"parts"`
+	Complete *PresignedRequest   `json:"complete"`
+}
+
+// PresignInitiateMultipartUpload : POST /key?uploads
+func (p *Presigner) PresignInitiateMultipartUpload(key string, options *MultipartOptions) (*PresignedRequest, error) {
+	if options == nil {
+		options = new(MultipartOptions)
+	}
+	if err := ValidateObjectKey(key); err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(options.Headers)+1)
+	for k, v := range options.Headers {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, "x-oss-") {
+			return nil, fmt.Errorf("unsupported signed header %q, only x-oss-* headers", k)
+		}
+		headers[name] = v
+	}
+	if options.ContentType != "" {
+		headers["content-type"] = options.ContentType
+	}
+	return p.presign("POST", key, url.Values{"uploads": {""}}, headers, options.Expires)
+}
+
+// PresignUploadPart : PUT /key?partNumber=N&uploadId=ID, partNumber starts at 1
+func (p *Presigner) PresignUploadPart(key string, uploadId string, partNumber int, expires time.Duration) (*PresignedRequest, error) {
+	if err := ValidateObjectKey(key); err != nil {
+		return nil, err
+	}
+	if uploadId == "" {
+		return nil, fmt.Errorf("missing required upload id")
+	}
+	if partNumber < 1 || partNumber > MaxMultipartParts {
+		return nil, fmt.Errorf("invalid part number %d, must be between 1 and %d", partNumber, MaxMultipartParts)
+	}
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadId}}
+	return p.presign("PUT", key, query, nil, expires)
+}
+
+// PresignCompleteMultipartUpload : POST /key?uploadId=ID, the callback config is embedded as for PresignPutObject
+// so the callback body arrives with operation CompleteMultipartUpload
+func (p *Presigner) PresignCompleteMultipartUpload(key string, uploadId string, options *MultipartOptions) (*PresignedRequest, error) {
+	if options == nil {
+		options = new(MultipartOptions)
+	}
+	if err := ValidateObjectKey(key); err != nil {
+		return nil, err
+	}
+	if uploadId == "" {
+		return nil, fmt.Errorf("missing required upload id")
+	}
+
+	query := url.Values{"uploadId": {uploadId}}
+	callbackBase64, callbackVarBase64, err := encodeCallback(p.callback, options.CallbackVars)
+	if err != nil {
+		return nil, err
+	}
+	if callbackBase64 != "" {
+		query.Set("callback", callbackBase64)
+	}
+	if callbackVarBase64 != "" {
+		query.Set("callback-var", callbackVarBase64)
+	}
+	// the Content-Type is signed, browsers would otherwise send text/plain for the xml body
+	headers := map[string]string{"content-type": CompleteMultipartUploadContentType}
+	return p.presign("POST", key, query, headers, options.Expires)
+}
+
+// MultipartCoordinator : the appserver initiates the multipart upload, the client uploads the parts and completes it
+// https://help.aliyun.com/zh/oss/user-guide/multipart-upload
+type MultipartCoordinator struct {
+	Client *http.Client
+
+	presigner *Presigner
+}
+
+func NewMultipartCoordinator(config *Config) *MultipartCoordinator {
+	return &MultipartCoordinator{
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		presigner: NewPresigner(config),
+	}
+}
+
+func (c *MultipartCoordinator) SetCallback(callback *Callback) *MultipartCoordinator {
+	k := *c
+	k.presigner = c.presigner.SetCallback(callback)
+	return &k
+}
+
+// Start : InitiateMultipartUpload, then presign partCount UploadPart and the CompleteMultipartUpload requests
+func (c *MultipartCoordinator) Start(key string, partCount int, options *MultipartOptions) (*MultipartUpload, error) {
+	if options == nil {
+		options = new(MultipartOptions)
+	}
+	if partCount < 1 || partCount > MaxMultipartParts {
+		return nil, fmt.Errorf("invalid part count %d, must be between 1 and %d", partCount, MaxMultipartParts)
+	}
+	// callback and vars are checked before the upload is initiated
+	if _, _, err := encodeCallback(c.presigner.callback, options.CallbackVars); err != nil {
+		return nil, err
+	}
+
+	uploadId, err := c.Initiate(key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{
+		Key:      key,
+		UploadId: uploadId,
+		Parts:    make([]*PresignedRequest, 0, partCount),
+	}
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		part, err := c.presigner.PresignUploadPart(key, uploadId, partNumber, options.Expires)
+		if err != nil {
+			return nil, err
+		}
+		upload.Parts = append(upload.Parts, part)
+	}
+	if upload.Complete, err = c.presigner.PresignCompleteMultipartUpload(key, uploadId, options); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Initiate : execute InitiateMultipartUpload and return the upload id
+func (c *MultipartCoordinator) Initiate(key string, options *MultipartOptions) (string, error) {
+	initiate, err := c.presigner.PresignInitiateMultipartUpload(key, options)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(initiate.Method, initiate.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range initiate.SignedHeaders {
+		req.Header.Set(k, v)
+	}
+	body, err := doRequest(httpClient(c.Client), req)
+	if err != nil {
+		return "", fmt.Errorf("initiate multipart upload: %w", err)
+	}
+
+	var result struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadId string   `xml:"UploadId"`
+	}
+	if err = xml.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("initiate multipart upload: %w", err)
+	}
+	if result.UploadId == "" {
+		return "", fmt.Errorf("initiate multipart upload: missing upload id")
+	}
+	return result.UploadId, nil
+}
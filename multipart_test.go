@@ -0,0 +1,260 @@
+package appserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPresignMultipartUpload(t *testing.T) {
+	callback := &Callback{CallbackUrl: "http://domain.com/oss/callback", CallbackBody: "object=${object}&operation=${operation}", CallbackBodyType: CallbackBodyTypeForm}
+	uploadId := "0004B9894A22E5B1888A1E29F823****"
+
+	for signatureVersion, expectUrls := range map[string][2]string{
+		SignatureVersionV1: {
+			"https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/video%201.mp4?Expires=1735689600&OSSAccessKeyId=yourAccessKeyId&Signature=9Oq0Hm6X19U2zAkhUmfmpOpiutE%3D&partNumber=2&uploadId=0004B9894A22E5B1888A1E29F823%2A%2A%2A%2A",
+			"https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/video%201.mp4?Expires=1735689600&OSSAccessKeyId=yourAccessKeyId&Signature=R8csJPQcUpGdzQWbxoxGCBNXtlc%3D&callback=eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayIsImNhbGxiYWNrQm9keSI6Im9iamVjdD0ke29iamVjdH1cdTAwMjZvcGVyYXRpb249JHtvcGVyYXRpb259IiwiY2FsbGJhY2tCb2R5VHlwZSI6ImFwcGxpY2F0aW9uL3gtd3d3LWZvcm0tdXJsZW5jb2RlZCJ9&uploadId=0004B9894A22E5B1888A1E29F823%2A%2A%2A%2A",
+		},
+		SignatureVersionV4: {
+			"https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/video%201.mp4?partNumber=2&uploadId=0004B9894A22E5B1888A1E29F823%2A%2A%2A%2A&x-oss-credential=yourAccessKeyId%2F20241231%2Fcn-hangzhou%2Foss%2Faliyun_v4_request&x-oss-date=20241231T235000Z&x-oss-expires=600&x-oss-signature=73546521a24852a5230b427e9cf519b498658b5dcb026d804ef5737370014b51&x-oss-signature-version=OSS4-HMAC-SHA256",
+			"https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/video%201.mp4?callback=eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayIsImNhbGxiYWNrQm9keSI6Im9iamVjdD0ke29iamVjdH1cdTAwMjZvcGVyYXRpb249JHtvcGVyYXRpb259IiwiY2FsbGJhY2tCb2R5VHlwZSI6ImFwcGxpY2F0aW9uL3gtd3d3LWZvcm0tdXJsZW5jb2RlZCJ9&uploadId=0004B9894A22E5B1888A1E29F823%2A%2A%2A%2A&x-oss-credential=yourAccessKeyId%2F20241231%2Fcn-hangzhou%2Foss%2Faliyun_v4_request&x-oss-date=20241231T235000Z&x-oss-expires=600&x-oss-signature=150e43f7593ba5330611511a41a5582aed6459dd527bab79512259d07f69db74&x-oss-signature-version=OSS4-HMAC-SHA256",
+		},
+	} {
+		t.Run(signatureVersion, func(t *testing.T) {
+			presigner := newTestPresigner(signatureVersion).SetCallback(callback)
+			part, err := presigner.PresignUploadPart("user-dir-prefix/video 1.mp4", uploadId, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if part.Method != "PUT" || part.URL != expectUrls[0] {
+				t.Errorf("expect %s, got %s", expectUrls[0], part.URL)
+			}
+
+			complete, err := presigner.PresignCompleteMultipartUpload("user-dir-prefix/video 1.mp4", uploadId, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if complete.Method != "POST" || complete.URL != expectUrls[1] {
+				t.Errorf("expect %s, got %s", expectUrls[1], complete.URL)
+			}
+			if complete.SignedHeaders["content-type"] != CompleteMultipartUploadContentType {
+				t.Error("signed headers error", complete.SignedHeaders)
+			}
+		})
+	}
+}
+
+func TestPresignMultipartUploadInvalid(t *testing.T) {
+	presigner := newTestPresigner(SignatureVersionV1)
+	if _, err := presigner.PresignUploadPart("video.mp4", "", 1, 0); err == nil {
+		t.Error("missing upload id")
+	}
+	for _, partNumber := range []int{0, MaxMultipartParts + 1} {
+		if _, err := presigner.PresignUploadPart("video.mp4", "upload-id", partNumber, 0); err == nil {
+			t.Errorf("part number %d error", partNumber)
+		}
+	}
+	if _, err := presigner.PresignInitiateMultipartUpload("video.mp4", &MultipartOptions{Headers: map[string]string{"Cache-Control": "no-cache"}}); err == nil {
+		t.Error("unsupported header")
+	}
+	if _, err := presigner.PresignCompleteMultipartUpload("video.mp4", "upload-id", &MultipartOptions{CallbackVars: map[string]string{"user_id": "123"}}); err == nil {
+		t.Error("callback vars without callback")
+	}
+}
+
+// fakeOSSSignatureV1 : the V1 url signature of the request as OSS recomputes it, every query param other than
+// OSSAccessKeyId, Expires and Signature is a sub-resource
+func fakeOSSSignatureV1(r *http.Request, secret string, bucket string) string {
+	query := r.URL.Query()
+	var ossHeaders []string
+	for k := range r.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-oss-") {
+			ossHeaders = append(ossHeaders, k+":"+r.Header.Get(k)+"\n")
+		}
+	}
+	sort.Strings(ossHeaders)
+	// sorted by name, callback goes before callback-var
+	var names []string
+	for k := range query {
+		if k != "OSSAccessKeyId" && k != "Expires" && k != "Signature" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var subResources []string
+	for _, k := range names {
+		if query.Get(k) == "" {
+			subResources = append(subResources, k)
+		} else {
+			subResources = append(subResources, k+"="+query.Get(k))
+		}
+	}
+	resource := "/" + bucket + r.URL.Path
+	if len(subResources) > 0 {
+		resource += "?" + strings.Join(subResources, "&")
+	}
+	stringToSign := r.Method + "\n" + r.Header.Get("Content-MD5") + "\n" + r.Header.Get("Content-Type") + "\n" +
+		query.Get("Expires") + "\n" + strings.Join(ossHeaders, "") + resource
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newFakeOSS : virtual-hosted bucket that answers the multipart requests and renders the callback body
+func newFakeOSS(t *testing.T, callbackBodies chan<- *CallbackBody) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("OSSAccessKeyId") != "yourAccessKeyId" || query.Get("Signature") != fakeOSSSignatureV1(r, "yourAccessKeySecret", "bucket-name") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == "POST" && query.Has("uploads"):
+			if r.Header.Get("Content-Type") != "video/mp4" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<InitiateMultipartUploadResult>
+  <Bucket>bucket-name</Bucket>
+  <Key>`+strings.TrimPrefix(r.URL.Path, "/")+`</Key>
+  <UploadId>0004B9894A22E5B1888A1E29F823****</UploadId>
+</InitiateMultipartUploadResult>`)
+		case r.Method == "PUT" && query.Get("uploadId") == "0004B9894A22E5B1888A1E29F823****":
+			w.Header().Set("ETag", `"`+query.Get("partNumber")+`"`)
+		case r.Method == "POST" && query.Get("uploadId") == "0004B9894A22E5B1888A1E29F823****":
+			if r.Header.Get("Content-Type") != CompleteMultipartUploadContentType {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			callbackByte, _ := base64.StdEncoding.DecodeString(query.Get("callback"))
+			callbackVarByte, _ := base64.StdEncoding.DecodeString(query.Get("callback-var"))
+			var callback Callback
+			values := map[string]string{
+				"bucket":    "bucket-name",
+				"object":    strings.TrimPrefix(r.URL.Path, "/"),
+				"size":      "10485760",
+				"crc64":     "34616313172852000",
+				"operation": "CompleteMultipartUpload",
+			}
+			if err := json.Unmarshal(callbackByte, &callback); err != nil {
+				t.Error(err)
+			}
+			if err := json.Unmarshal(callbackVarByte, &values); len(callbackVarByte) > 0 && err != nil {
+				t.Error(err)
+			}
+			callbackBody, err := DecodeCallbackBody(callback.CallbackBodyType, []byte(renderCallbackBody(callback.CallbackBody, callback.CallbackBodyType, values)))
+			if err != nil {
+				t.Error(err)
+			}
+			callbackBodies <- callbackBody
+			io.WriteString(w, `{"Status":"OK"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestMultipartCoordinator(t *testing.T) {
+	callbackBodies := make(chan *CallbackBody, 1)
+	server := newFakeOSS(t, callbackBodies)
+	defer server.Close()
+
+	coordinator := NewMultipartCoordinator(&Config{
+		AccessKeyId:     "yourAccessKeyId",
+		AccessKeySecret: "yourAccessKeySecret",
+		Host:            server.URL,
+		Bucket:          "bucket-name",
+		CallbackUrl:     "http://domain.com/oss/callback",
+	})
+	upload, err := coordinator.Start("user-dir-prefix/video.mp4", 3, &MultipartOptions{
+		ContentType:  "video/mp4",
+		CallbackVars: map[string]string{"user_id": "123"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Key != "user-dir-prefix/video.mp4" || upload.UploadId != "0004B9894A22E5B1888A1E29F823****" || len(upload.Parts) != 3 {
+		t.Fatal("multipart upload error", upload)
+	}
+
+	var completeXml strings.Builder
+	completeXml.WriteString("<CompleteMultipartUpload>")
+	for i, part := range upload.Parts {
+		if !strings.Contains(part.URL, "partNumber="+strconv.Itoa(i+1)) {
+			t.Error("part url error", part.URL)
+		}
+		req, _ := http.NewRequest(part.Method, part.URL, strings.NewReader("part"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		completeXml.WriteString("<Part><PartNumber>" + strconv.Itoa(i+1) + "</PartNumber><ETag>" + resp.Header.Get("ETag") + "</ETag></Part>")
+	}
+	completeXml.WriteString("</CompleteMultipartUpload>")
+
+	// the signature covers the sub-resources and the content type
+	for name, req := range map[string]*http.Request{
+		"part number":  httptest.NewRequest(upload.Parts[0].Method, strings.Replace(upload.Parts[0].URL, "partNumber=1", "partNumber=4", 1), nil),
+		"content type": httptest.NewRequest(upload.Complete.Method, upload.Complete.URL, nil),
+	} {
+		w := httptest.NewRecorder()
+		server.Config.Handler.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: tampered request must be rejected, got %d", name, w.Code)
+		}
+	}
+
+	req, _ := http.NewRequest(upload.Complete.Method, upload.Complete.URL, strings.NewReader(completeXml.String()))
+	for k, v := range upload.Complete.SignedHeaders {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("complete multipart upload status", resp.StatusCode)
+	}
+
+	callbackBody := <-callbackBodies
+	if callbackBody.Operation != "CompleteMultipartUpload" || callbackBody.Object != "user-dir-prefix/video.mp4" || callbackBody.Size != 10485760 {
+		t.Error("callback body error", callbackBody)
+	}
+	if callbackBody.Var("user_id") != "123" {
+		t.Error("callback var error", callbackBody.Vars)
+	}
+}
+
+func TestMultipartCoordinatorInitiateError(t *testing.T) {
+	server := newFakeOSS(t, nil)
+	defer server.Close()
+
+	coordinator := NewMultipartCoordinator(&Config{
+		AccessKeyId:     "yourAccessKeyId",
+		AccessKeySecret: "yourAccessKeySecret",
+		Host:            server.URL,
+		Bucket:          "bucket-name",
+	})
+	// the fake bucket rejects an initiate request without the signed content type
+	if _, err := coordinator.Start("video.mp4", 1, nil); err == nil {
+		t.Error("initiate error")
+	}
+	if _, err := coordinator.Start("video.mp4", 0, nil); err == nil {
+		t.Error("part count error")
+	}
+	if _, err := coordinator.Start("video.mp4", 1, &MultipartOptions{CallbackVars: map[string]string{"user_id": "123"}}); err == nil {
+		t.Error("callback vars without callback")
+	}
+}
@@ -0,0 +1,187 @@
+package appserver
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Policy condition operators
+// https://help.aliyun.com/zh/oss/developer-reference/postobject#section-d5z-1ww-wdb
+const PolicyConditionEq = "eq"
+const PolicyConditionStartsWith = "starts-with"
+const PolicyConditionIn = "in"
+const PolicyConditionNotIn = "not-in"
+const PolicyConditionContentLengthRange = "content-length-range"
+
+const MetaFieldPrefix = "x-oss-meta-"
+
+type policyValueKind int
+
+const (
+	policyValueString policyValueKind = iota
+	policyValueStatus
+	policyValueRedirect
+	policyValueAcl
+	policyValueStorageClass
+	policyValueBool
+)
+
+type policyField struct {
+	kind       policyValueKind
+	startsWith bool
+}
+
+// policyFields : form fields allowed in eq and starts-with conditions, x-oss-meta-* is matched separately
+var policyFields = map[string]policyField{
+	"key":                     {kind: policyValueString, startsWith: true},
+	"content-type":            {kind: policyValueString, startsWith: true},
+	"cache-control":           {kind: policyValueString, startsWith: true},
+	"content-disposition":     {kind: policyValueString, startsWith: true},
+	"content-encoding":        {kind: policyValueString, startsWith: true},
+	"success_action_status":   {kind: policyValueStatus},
+	"success_action_redirect": {kind: policyValueRedirect, startsWith: true},
+	"x-oss-object-acl":        {kind: policyValueAcl},
+	"x-oss-storage-class":     {kind: policyValueStorageClass},
+	"x-oss-forbid-overwrite":  {kind: policyValueBool},
+}
+
+var successActionStatuses = []string{"200", "201", "204"}
+var objectAcls = []string{"default", "private", "public-read", "public-read-write"}
+var storageClasses = []string{"Standard", "IA", "Archive", "ColdArchive", "DeepColdArchive"}
+
+// lookupPolicyField : normalized field name without "$", case-insensitive as form fields are
+func lookupPolicyField(field string) (string, policyField, error) {
+	name := strings.ToLower(strings.TrimPrefix(field, "$"))
+	if f, ok := policyFields[name]; ok {
+		return name, f, nil
+	}
+	if strings.HasPrefix(name, MetaFieldPrefix) {
+		if err := validateMetaName(strings.TrimPrefix(name, MetaFieldPrefix)); err != nil {
+			return "", policyField{}, err
+		}
+		return name, policyField{kind: policyValueString, startsWith: true}, nil
+	}
+	return "", policyField{}, fmt.Errorf("unsupported policy condition field %q", field)
+}
+
+func validateMetaName(name string) error {
+	if name == "" {
+		return fmt.Errorf("missing required meta name")
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid character %q in meta name %q", c, name)
+		}
+	}
+	return nil
+}
+
+func (k policyValueKind) validate(field string, value string) error {
+	switch k {
+	case policyValueStatus:
+		return validateOneOf(field, value, successActionStatuses)
+	case policyValueRedirect:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid policy condition %s value %q, must be an http or https url", field, value)
+		}
+	case policyValueAcl:
+		return validateOneOf(field, value, objectAcls)
+	case policyValueStorageClass:
+		return validateOneOf(field, value, storageClasses)
+	case policyValueBool:
+		return validateOneOf(field, value, []string{"true", "false"})
+	}
+	for _, c := range value {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("invalid control character in policy condition %s value", field)
+		}
+	}
+	return nil
+}
+
+func validateOneOf(field string, value string, allowed []string) error {
+	for _, v := range allowed {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid policy condition %s value %q, must be one of %s", field, value, strings.Join(allowed, ", "))
+}
+
+// SetEqual : ["eq", "$field", value]
+func (c *Policy) SetEqual(field string, value string) error {
+	name, f, err := lookupPolicyField(field)
+	if err != nil {
+		return err
+	}
+	if err = f.kind.validate(name, value); err != nil {
+		return err
+	}
+	c.Conditions = append(c.Conditions, []any{
+		PolicyConditionEq, "$" + name, value,
+	})
+	return nil
+}
+
+// SetStartsWith : ["starts-with", "$field", prefix]
+func (c *Policy) SetStartsWith(field string, prefix string) error {
+	name, f, err := lookupPolicyField(field)
+	if err != nil {
+		return err
+	}
+	if !f.startsWith {
+		return fmt.Errorf("policy condition field %s does not support %s", name, PolicyConditionStartsWith)
+	}
+	if f.kind == policyValueString {
+		if err = f.kind.validate(name, prefix); err != nil {
+			return err
+		}
+	}
+	c.Conditions = append(c.Conditions, []any{
+		PolicyConditionStartsWith, "$" + name, prefix,
+	})
+	return nil
+}
+
+// SetMeta : x-oss-meta-<name>
+func (c *Policy) SetMeta(name string, value string) error {
+	return c.SetEqual(MetaFieldPrefix+name, value)
+}
+
+func (c *Policy) SetCacheControl(cacheControl string) error {
+	return c.SetEqual("cache-control", cacheControl)
+}
+
+func (c *Policy) SetContentDisposition(contentDisposition string) error {
+	return c.SetEqual("content-disposition", contentDisposition)
+}
+
+func (c *Policy) SetContentEncoding(contentEncoding string) error {
+	return c.SetEqual("content-encoding", contentEncoding)
+}
+
+// SetSuccessActionStatus : 200, 201 or 204
+func (c *Policy) SetSuccessActionStatus(status int) error {
+	return c.SetEqual("success_action_status", strconv.Itoa(status))
+}
+
+func (c *Policy) SetSuccessActionRedirect(redirect string) error {
+	return c.SetEqual("success_action_redirect", redirect)
+}
+
+// SetObjectAcl : default, private, public-read or public-read-write
+func (c *Policy) SetObjectAcl(acl string) error {
+	return c.SetEqual("x-oss-object-acl", acl)
+}
+
+// SetStorageClass : Standard, IA, Archive, ColdArchive or DeepColdArchive
+func (c *Policy) SetStorageClass(storageClass string) error {
+	return c.SetEqual("x-oss-storage-class", storageClass)
+}
+
+func (c *Policy) SetForbidOverwrite(forbid bool) error {
+	return c.SetEqual("x-oss-forbid-overwrite", strconv.FormatBool(forbid))
+}
@@ -0,0 +1,57 @@
+package appserver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPolicyConditions(t *testing.T) {
+	policy := new(Policy)
+	for _, err := range []error{
+		policy.SetMeta("user-id", "123"),
+		policy.SetCacheControl("no-cache"),
+		policy.SetContentDisposition("attachment"),
+		policy.SetContentEncoding("gzip"),
+		policy.SetSuccessActionStatus(201),
+		policy.SetSuccessActionRedirect("https://domain.com/uploaded"),
+		policy.SetObjectAcl("private"),
+		policy.SetStorageClass("IA"),
+		policy.SetForbidOverwrite(true),
+		policy.SetStartsWith("$Content-Type", "image/"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conditionsJson, _ := json.Marshal(policy.Conditions)
+	expectConditionsStr := `[["eq","$x-oss-meta-user-id","123"],["eq","$cache-control","no-cache"],["eq","$content-disposition","attachment"],["eq","$content-encoding","gzip"],["eq","$success_action_status","201"],["eq","$success_action_redirect","https://domain.com/uploaded"],["eq","$x-oss-object-acl","private"],["eq","$x-oss-storage-class","IA"],["eq","$x-oss-forbid-overwrite","true"],["starts-with","$content-type","image/"]]`
+	if string(conditionsJson) != expectConditionsStr {
+		t.Errorf("expect %s, got %s", expectConditionsStr, conditionsJson)
+	}
+}
+
+func TestPolicyConditionsInvalid(t *testing.T) {
+	policy := new(Policy)
+	for name, err := range map[string]error{
+		"unknown field":         policy.SetEqual("x-oss-unknown", "value"),
+		"empty meta name":       policy.SetMeta("", "value"),
+		"invalid meta name":     policy.SetMeta("user id", "value"),
+		"invalid status":        policy.SetSuccessActionStatus(302),
+		"invalid redirect":      policy.SetSuccessActionRedirect("ftp://domain.com"),
+		"invalid acl":           policy.SetObjectAcl("public"),
+		"invalid storage class": policy.SetStorageClass("standard"),
+		"control character":     policy.SetCacheControl("no-cache\r\n"),
+		"starts-with status":    policy.SetStartsWith("success_action_status", "2"),
+		"starts-with acl":       policy.SetStartsWith("x-oss-object-acl", "public"),
+		"starts-with overwrite": policy.SetStartsWith("x-oss-forbid-overwrite", "t"),
+		"starts-with bad field": policy.SetStartsWith("bucket", "bucket-"),
+	} {
+		if err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	if len(policy.Conditions) != 0 {
+		t.Error("invalid conditions added", policy.Conditions)
+	}
+}
@@ -0,0 +1,191 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const PolicyConditionExpiration = "expiration"
+
+// PolicyViolation : a policy condition the form does not satisfy
+type PolicyViolation struct {
+	Index     int    `json:"index"`     // position in conditions, -1 for expiration
+	Condition any    `json:"condition"` // decoded condition as signed
+	Operator  string `json:"operator"`  // eq, starts-with, in, not-in, content-length-range, expiration
+	Field     string `json:"field"`     // lower-case form field name without "$"
+	Reason    string `json:"reason"`
+}
+
+func (v PolicyViolation) Error() string {
+	if v.Field == "" {
+		return fmt.Sprintf("policy %s: %s", v.Operator, v.Reason)
+	}
+	return fmt.Sprintf("policy %s %s: %s", v.Operator, v.Field, v.Reason)
+}
+
+// EvaluatePolicy : check the form fields and file size of a PostObject request against a base64 policy,
+// as OSS would before accepting the upload. Form field names are case-insensitive; the bucket is not a form
+// field and has to be passed in fields as "bucket" when the policy restricts it. An empty result means accepted.
+func EvaluatePolicy(policyBase64 string, fields map[string]string, fileSize int64, at time.Time) ([]PolicyViolation, error) {
+	policyByte, err := base64.StdEncoding.DecodeString(policyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy base64: %w", err)
+	}
+	var policy struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err = json.Unmarshal(policyByte, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy json: %w", err)
+	}
+	expiredAt, err := time.Parse(TimeGMTISO8601, policy.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy expiration %q: %w", policy.Expiration, err)
+	}
+
+	form := make(map[string]string, len(fields))
+	for k, v := range fields {
+		form[strings.ToLower(k)] = v
+	}
+
+	violations := make([]PolicyViolation, 0)
+	if !at.Before(expiredAt) {
+		violations = append(violations, PolicyViolation{
+			Index:     -1,
+			Condition: policy.Expiration,
+			Operator:  PolicyConditionExpiration,
+			Reason:    fmt.Sprintf("policy expired at %s", policy.Expiration),
+		})
+	}
+	for i, raw := range policy.Conditions {
+		conditionViolations, err := evaluateCondition(i, raw, form, fileSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy condition %d %s: %w", i, raw, err)
+		}
+		violations = append(violations, conditionViolations...)
+	}
+	return violations, nil
+}
+
+func evaluateCondition(index int, raw json.RawMessage, form map[string]string, fileSize int64) ([]PolicyViolation, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		return evaluateMapCondition(index, raw, form)
+	}
+
+	var condition []any
+	if err := json.Unmarshal(raw, &condition); err != nil {
+		return nil, err
+	}
+	if len(condition) != 3 {
+		return nil, fmt.Errorf("expect 3 elements, got %d", len(condition))
+	}
+	operator, ok := condition[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("operator must be a string")
+	}
+	operator = strings.ToLower(operator)
+	violation := PolicyViolation{Index: index, Condition: condition, Operator: operator}
+
+	if operator == PolicyConditionContentLengthRange {
+		lower, lowerOk := condition[1].(float64)
+		upper, upperOk := condition[2].(float64)
+		if !lowerOk || !upperOk {
+			return nil, fmt.Errorf("content-length-range bounds must be numbers")
+		}
+		if float64(fileSize) < lower || float64(fileSize) > upper {
+			violation.Reason = fmt.Sprintf("file size %d not in range [%d, %d]", fileSize, int64(lower), int64(upper))
+			return []PolicyViolation{violation}, nil
+		}
+		return nil, nil
+	}
+
+	field, ok := condition[1].(string)
+	if !ok || !strings.HasPrefix(field, "$") {
+		return nil, fmt.Errorf("field must be a string starting with $")
+	}
+	violation.Field = strings.ToLower(strings.TrimPrefix(field, "$"))
+	value, present := form[violation.Field]
+
+	switch operator {
+	case PolicyConditionEq:
+		expect, ok := condition[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("eq value must be a string")
+		}
+		if !present || value != expect {
+			violation.Reason = fmt.Sprintf("value %q does not equal %q", value, expect)
+			return []PolicyViolation{violation}, nil
+		}
+	case PolicyConditionStartsWith:
+		prefix, ok := condition[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("starts-with value must be a string")
+		}
+		if !strings.HasPrefix(value, prefix) || (!present && prefix != "") {
+			violation.Reason = fmt.Sprintf("value %q does not start with %q", value, prefix)
+			return []PolicyViolation{violation}, nil
+		}
+	case PolicyConditionIn, PolicyConditionNotIn:
+		list, ok := condition[2].([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s value must be a list", operator)
+		}
+		found := false
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s list items must be strings", operator)
+			}
+			found = found || (present && s == value)
+		}
+		if operator == PolicyConditionIn && !found {
+			violation.Reason = fmt.Sprintf("value %q not in %v", value, list)
+			return []PolicyViolation{violation}, nil
+		}
+		if operator == PolicyConditionNotIn && found {
+			violation.Reason = fmt.Sprintf("value %q in %v", value, list)
+			return []PolicyViolation{violation}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", operator)
+	}
+	return nil, nil
+}
+
+// evaluateMapCondition : {"bucket": "bucket-name"} is an exact match on each key
+func evaluateMapCondition(index int, raw json.RawMessage, form map[string]string) ([]PolicyViolation, error) {
+	var condition map[string]any
+	if err := json.Unmarshal(raw, &condition); err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(condition))
+	for field := range condition {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var violations []PolicyViolation
+	for _, field := range fields {
+		expect, ok := condition[field].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s value must be a string", field)
+		}
+		name := strings.ToLower(strings.TrimPrefix(field, "$"))
+		if value, present := form[name]; !present || value != expect {
+			violations = append(violations, PolicyViolation{
+				Index:     index,
+				Condition: condition,
+				Operator:  PolicyConditionEq,
+				Field:     name,
+				Reason:    fmt.Sprintf("value %q does not equal %q", value, expect),
+			})
+		}
+	}
+	return violations, nil
+}
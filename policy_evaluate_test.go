@@ -0,0 +1,119 @@
+package appserver
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestEvaluatePolicy(t *testing.T) {
+	token := NewToken(&Config{
+		AccessKeyId:      "yourAccessKeyId",
+		AccessKeySecret:  "yourAccessKeySecret",
+		Host:             "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		SignatureVersion: SignatureVersionV4,
+	})
+	token.now = func() time.Time {
+		return time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	}
+
+	targetTime, _ := time.Parse("2006-01-02 15:04:05", "2025-01-01 00:00:00")
+	policy := new(Policy)
+	policy.SetExpireTime(targetTime)
+	policy.SetDirectory("user-dir-prefix/")
+	policy.SetBucket("bucket-name")
+	policy.SetContentLengthRange(1, 1024)
+	policy.SetContentType("image/jpeg", "image/png")
+	tokenPayload, err := token.SetPolicy(policy).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]string{
+		"bucket":                  "bucket-name",
+		"Key":                     "user-dir-prefix/image.jpg",
+		"Content-Type":            "image/png",
+		"x-oss-signature-version": tokenPayload.SignatureVersion,
+		"x-oss-credential":        tokenPayload.Credential,
+		"x-oss-date":              tokenPayload.Date,
+	}
+	signedAt := time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC)
+
+	t.Run("accepted", func(t *testing.T) {
+		violations, err := EvaluatePolicy(tokenPayload.Policy, fields, 512, signedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(violations) != 0 {
+			t.Error("unexpected violations", violations)
+		}
+	})
+
+	t.Run("violated", func(t *testing.T) {
+		form := map[string]string{}
+		for k, v := range fields {
+			form[k] = v
+		}
+		form["Key"] = "other-dir/image.jpg"
+		form["Content-Type"] = "image/gif"
+		delete(form, "bucket")
+
+		violations, err := EvaluatePolicy(tokenPayload.Policy, form, 2048, targetTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expects := []struct {
+			index    int
+			operator string
+			field    string
+		}{
+			{-1, PolicyConditionExpiration, ""},
+			{0, PolicyConditionStartsWith, "key"},
+			{1, PolicyConditionEq, "bucket"},
+			{2, PolicyConditionContentLengthRange, ""},
+			{3, PolicyConditionIn, "content-type"},
+		}
+		if len(violations) != len(expects) {
+			t.Fatalf("expect %d violations, got %v", len(expects), violations)
+		}
+		for i, expect := range expects {
+			v := violations[i]
+			if v.Index != expect.index || v.Operator != expect.operator || v.Field != expect.field {
+				t.Errorf("violation %d: expect %v, got %v", i, expect, v)
+			}
+		}
+	})
+}
+
+func TestEvaluatePolicyOperators(t *testing.T) {
+	policyBase64 := base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2025-01-01T00:00:00Z","conditions":[` +
+		`["not-in","$x-oss-storage-class",["Archive","ColdArchive"]],["eq","$success_action_status","201"],["starts-with","$cache-control",""]]}`))
+	at := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	violations, err := EvaluatePolicy(policyBase64, map[string]string{"success_action_status": "201"}, 0, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Error("unexpected violations", violations)
+	}
+
+	violations, err = EvaluatePolicy(policyBase64, map[string]string{"x-oss-storage-class": "Archive"}, 0, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 || violations[0].Operator != PolicyConditionNotIn || violations[1].Field != "success_action_status" {
+		t.Error("violations error", violations)
+	}
+
+	for name, policy := range map[string]string{
+		"base64":     "not-base64",
+		"expiration": base64.StdEncoding.EncodeToString([]byte(`{"conditions":[]}`)),
+		"operator":   base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2025-01-01T00:00:00Z","conditions":[["gt","$key","a"]]}`)),
+		"length":     base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2025-01-01T00:00:00Z","conditions":[["eq","$key"]]}`)),
+	} {
+		if _, err = EvaluatePolicy(policy, nil, 0, at); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
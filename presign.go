@@ -0,0 +1,311 @@
+package appserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxPresignExpiresV4 = 7 * 24 * time.Hour
+
+// v1SignedSubResources : query parameters that are part of the v1 CanonicalizedResource
+// https://help.aliyun.com/zh/oss/developer-reference/ddd-signatures-to-urls
+var v1SignedSubResources = map[string]bool{
+	"acl": true, "uploads": true, "location": true, "cors": true, "logging": true, "website": true,
+	"referer": true, "lifecycle": true, "delete": true, "append": true, "tagging": true, "objectMeta": true,
+	"uploadId": true, "partNumber": true, "security-token": true, "position": true, "img": true, "style": true,
+	"styleName": true, "replication": true, "replicationProgress": true, "replicationLocation": true,
+	"cname": true, "bucketInfo": true, "comp": true, "qos": true, "live": true, "status": true, "vod": true,
+	"startTime": true, "endTime": true, "symlink": true, "x-oss-process": true, "response-content-type": true,
+	"response-content-language": true, "response-expires": true, "response-cache-control": true,
+	"response-content-disposition": true, "response-content-encoding": true, "udf": true, "udfName": true,
+	"udfImage": true, "udfId": true, "udfImageDesc": true, "udfApplication": true, "udfApplicationLog": true,
+	"restore": true, "callback": true, "callback-var": true, "policy": true, "stat": true, "encryption": true,
+	"versions": true, "versioning": true, "versionId": true, "x-oss-traffic-limit": true,
+}
+
+// PresignOptions
+type PresignOptions struct {
+	Expires      time.Duration     // optional, default: Config.ExpireSecond
+	ContentType  string            // optional, signed, the client must send the same Content-Type
+	ContentMD5   string            // optional, signed, the client must send the same Content-MD5
+	Headers      map[string]string // optional, signed x-oss-* headers, e.g. x-oss-object-acl
+	CallbackVars map[string]string // optional, "user_id" or "x:user_id", wired into the callback body
+}
+
+// GetObjectOptions
+// https://help.aliyun.com/zh/oss/developer-reference/getobject
+type GetObjectOptions struct {
+	Expires                    time.Duration // optional, default: Config.ExpireSecond
+	ResponseContentDisposition string        // optional, e.g. attachment; filename="image.jpg"
+	ResponseContentType        string        // optional
+	VersionId                  string        // optional
+}
+
+// PresignedRequest : the client sends Method to URL with exactly the SignedHeaders
+type PresignedRequest struct {
+	Method        string            `json:"method"`
+	URL           string            `json:"url"`
+	SignedHeaders map[string]string `json:"signed_headers,omitempty"`
+	Expire        int64             `json:"expire"`
+}
+
+// Presigner : query string signed URLs for clients that upload with a simple PUT instead of a POST form
+type Presigner struct {
+	config   *Config
+	callback *Callback
+	now      func() time.Time
+}
+
+func NewPresigner(config *Config) *Presigner {
+	var callback *Callback
+	if config.CallbackUrl != "" {
+		callback = newCallback(config)
+	}
+	return &Presigner{
+		config:   config,
+		callback: callback,
+		now:      time.Now,
+	}
+}
+
+func (p *Presigner) SetCallback(callback *Callback) *Presigner {
+	k := *p
+	k.callback = callback
+	return &k
+}
+
+// PresignPutObject : the callback config is embedded as the callback and callback-var query parameters,
+// so the callback is verified by AliyunOSSCallback as for PostObject
+func (p *Presigner) PresignPutObject(key string, options *PresignOptions) (*PresignedRequest, error) {
+	if options == nil {
+		options = new(PresignOptions)
+	}
+	if err := ValidateObjectKey(key); err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(options.Headers)+2)
+	for k, v := range options.Headers {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, "x-oss-") {
+			return nil, fmt.Errorf("unsupported signed header %q, only x-oss-* headers", k)
+		}
+		headers[name] = v
+	}
+	if options.ContentType != "" {
+		headers["content-type"] = options.ContentType
+	}
+	if options.ContentMD5 != "" {
+		headers["content-md5"] = options.ContentMD5
+	}
+
+	query := url.Values{}
+	callbackBase64, callbackVarBase64, err := encodeCallback(p.callback, options.CallbackVars)
+	if err != nil {
+		return nil, err
+	}
+	if callbackBase64 != "" {
+		query.Set("callback", callbackBase64)
+	}
+	if callbackVarBase64 != "" {
+		query.Set("callback-var", callbackVarBase64)
+	}
+
+	return p.presign("PUT", key, query, headers, options.Expires)
+}
+
+// PresignGetObject : time-limited download link to a private object
+func (p *Presigner) PresignGetObject(key string, options *GetObjectOptions) (*PresignedRequest, error) {
+	if options == nil {
+		options = new(GetObjectOptions)
+	}
+	if err := ValidateObjectKey(key); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if options.ResponseContentDisposition != "" {
+		query.Set("response-content-disposition", options.ResponseContentDisposition)
+	}
+	if options.ResponseContentType != "" {
+		query.Set("response-content-type", options.ResponseContentType)
+	}
+	if options.VersionId != "" {
+		query.Set("versionId", options.VersionId)
+	}
+	return p.presign("GET", key, query, nil, options.Expires)
+}
+
+// presign : sign method, key, query and headers with the configured signature version
+func (p *Presigner) presign(method string, key string, query url.Values, headers map[string]string, expires time.Duration) (*PresignedRequest, error) {
+	if p.config.Host == "" {
+		return nil, configErrorf("host", "missing required config host")
+	}
+	bucket := p.config.signBucket()
+	if bucket == "" {
+		return nil, configErrorf("bucket", "missing required config bucket")
+	}
+	if expires == 0 {
+		expireSecond := p.config.ExpireSecond
+		if expireSecond == 0 {
+			expireSecond = DefaultExpireSecond
+		}
+		expires = time.Duration(expireSecond) * time.Second
+	}
+	if expires < time.Second {
+		return nil, configErrorf("Expires", "invalid presign expires %s", expires)
+	}
+
+	credentials, err := p.config.credentialsProvider().GetCredentials()
+	if err != nil {
+		return nil, err
+	}
+	signedAt := p.now()
+	expiredAt := signedAt.Add(expires)
+	if credentials.SecurityToken != "" && !credentials.Expiration.IsZero() && expiredAt.After(credentials.Expiration) {
+		return nil, fmt.Errorf("%w: presign expiration %s, security token expiration %s", ErrOutlivesSecurityToken,
+			expiredAt.UTC().Format(TimeGMTISO8601), credentials.Expiration.UTC().Format(TimeGMTISO8601))
+	}
+
+	query = cloneValues(query)
+	if p.config.SignatureVersion == SignatureVersionV4 {
+		err = p.signV4(method, bucket, key, query, headers, credentials, signedAt, expires)
+	} else {
+		p.signV1(method, bucket, key, query, headers, credentials, expiredAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	host := p.config.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	var signedHeaders map[string]string
+	if len(headers) > 0 {
+		signedHeaders = make(map[string]string, len(headers))
+		for k, v := range headers {
+			signedHeaders[k] = v
+		}
+	}
+	return &PresignedRequest{
+		Method:        method,
+		URL:           strings.TrimRight(host, "/") + "/" + uriEncode(key, true) + "?" + encodeQuery(query),
+		SignedHeaders: signedHeaders,
+		Expire:        expiredAt.Unix(),
+	}, nil
+}
+
+// signV1 : VERB\nContent-MD5\nContent-Type\nExpires\nCanonicalizedOSSHeaders CanonicalizedResource
+// https://help.aliyun.com/zh/oss/developer-reference/ddd-signatures-to-urls
+func (p *Presigner) signV1(method string, bucket string, key string, query url.Values, headers map[string]string, credentials *Credentials, expiredAt time.Time) {
+	if credentials.SecurityToken != "" {
+		query.Set("security-token", credentials.SecurityToken)
+	}
+	expiresStr := strconv.FormatInt(expiredAt.Unix(), 10)
+
+	var ossHeaders strings.Builder
+	for _, name := range sortedKeys(headers) {
+		if strings.HasPrefix(name, "x-oss-") {
+			ossHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+		}
+	}
+
+	resource := "/" + bucket + "/" + key
+	var subResources []string
+	for _, name := range sortedKeys(query) {
+		if !v1SignedSubResources[name] {
+			continue
+		}
+		if value := query.Get(name); value != "" {
+			subResources = append(subResources, name+"="+value)
+		} else {
+			subResources = append(subResources, name)
+		}
+	}
+	if len(subResources) > 0 {
+		resource += "?" + strings.Join(subResources, "&")
+	}
+
+	stringToSign := method + "\n" + headers["content-md5"] + "\n" + headers["content-type"] + "\n" + expiresStr + "\n" +
+		ossHeaders.String() + resource
+	query.Set("OSSAccessKeyId", credentials.AccessKeyId)
+	query.Set("Expires", expiresStr)
+	query.Set("Signature", signV1(credentials.AccessKeySecret, stringToSign))
+}
+
+// signV4 : OSS4-HMAC-SHA256\nx-oss-date\nscope\nhex(sha256(CanonicalRequest))
+// https://help.aliyun.com/zh/oss/developer-reference/add-signatures-to-urls
+func (p *Presigner) signV4(method string, bucket string, key string, query url.Values, headers map[string]string, credentials *Credentials, signedAt time.Time, expires time.Duration) error {
+	if expires > MaxPresignExpiresV4 {
+		return configErrorf("Expires", "presign expires %s exceeds %s for signature version v4", expires, MaxPresignExpiresV4)
+	}
+	region := p.config.signRegion()
+	if region == "" {
+		return configErrorf("region", "missing required config region for signature version v4")
+	}
+	date := signedAt.UTC().Format(TimeISO8601Basic)
+	scope := credentialScopeV4(signedAt, region)
+
+	query.Set("x-oss-signature-version", SignatureAlgorithmV4)
+	query.Set("x-oss-date", date)
+	query.Set("x-oss-expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("x-oss-credential", credentials.AccessKeyId+"/"+scope)
+	if credentials.SecurityToken != "" {
+		query.Set("x-oss-security-token", credentials.SecurityToken)
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range sortedKeys(headers) {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		"/" + bucket + "/" + uriEncode(key, true),
+		encodeQuery(query),
+		canonicalHeaders.String(),
+		"",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := SignatureAlgorithmV4 + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	query.Set("x-oss-signature", signV4(credentials.AccessKeySecret, signedAt, region, stringToSign))
+	return nil
+}
+
+// encodeQuery : sorted by name, RFC 3986 encoded, empty values without "="
+func encodeQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for _, name := range sortedKeys(query) {
+		if value := query.Get(name); value != "" {
+			pairs = append(pairs, uriEncode(name, false)+"="+uriEncode(value, false))
+		} else {
+			pairs = append(pairs, uriEncode(name, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for k, v := range values {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
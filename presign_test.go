@@ -0,0 +1,130 @@
+package appserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestPresigner(signatureVersion string) *Presigner {
+	presigner := NewPresigner(&Config{
+		AccessKeyId:      "yourAccessKeyId",
+		AccessKeySecret:  "yourAccessKeySecret",
+		Host:             "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		SignatureVersion: signatureVersion,
+	})
+	presigner.now = func() time.Time {
+		return time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	}
+	return presigner
+}
+
+func TestPresignPutObject(t *testing.T) {
+	callback := &Callback{CallbackUrl: "http://domain.com/oss/callback", CallbackBody: "object=${object}", CallbackBodyType: CallbackBodyTypeForm}
+	options := &PresignOptions{
+		ContentType:  "image/jpeg",
+		Headers:      map[string]string{"X-Oss-Object-Acl": "private"},
+		CallbackVars: map[string]string{"user_id": "123"},
+	}
+
+	for signatureVersion, expectUrl := range map[string]string{
+		SignatureVersionV1: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/image%201.jpg?Expires=1735689600&OSSAccessKeyId=yourAccessKeyId&Signature=fCkXCtiXlB0FjbpRRT0ShO0T84g%3D&callback=eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayIsImNhbGxiYWNrQm9keSI6Im9iamVjdD0ke29iamVjdH1cdTAwMjZ4OnVzZXJfaWQ9JHt4OnVzZXJfaWR9IiwiY2FsbGJhY2tCb2R5VHlwZSI6ImFwcGxpY2F0aW9uL3gtd3d3LWZvcm0tdXJsZW5jb2RlZCJ9&callback-var=eyJ4OnVzZXJfaWQiOiIxMjMifQ%3D%3D",
+		SignatureVersionV4: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/image%201.jpg?callback=eyJjYWxsYmFja1VybCI6Imh0dHA6Ly9kb21haW4uY29tL29zcy9jYWxsYmFjayIsImNhbGxiYWNrQm9keSI6Im9iamVjdD0ke29iamVjdH1cdTAwMjZ4OnVzZXJfaWQ9JHt4OnVzZXJfaWR9IiwiY2FsbGJhY2tCb2R5VHlwZSI6ImFwcGxpY2F0aW9uL3gtd3d3LWZvcm0tdXJsZW5jb2RlZCJ9&callback-var=eyJ4OnVzZXJfaWQiOiIxMjMifQ%3D%3D&x-oss-credential=yourAccessKeyId%2F20241231%2Fcn-hangzhou%2Foss%2Faliyun_v4_request&x-oss-date=20241231T235000Z&x-oss-expires=600&x-oss-signature=302e45debd413311dee819eab7adfe94c70c437dcb0c80b15416c6403705c0ae&x-oss-signature-version=OSS4-HMAC-SHA256",
+	} {
+		t.Run(signatureVersion, func(t *testing.T) {
+			req, err := newTestPresigner(signatureVersion).SetCallback(callback).PresignPutObject("user-dir-prefix/image 1.jpg", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != expectUrl {
+				t.Errorf("expect %s, got %s", expectUrl, req.URL)
+			}
+			if req.Method != "PUT" || req.Expire != 1735689600 {
+				t.Error("presigned request error", req)
+			}
+			if len(req.SignedHeaders) != 2 || req.SignedHeaders["content-type"] != "image/jpeg" || req.SignedHeaders["x-oss-object-acl"] != "private" {
+				t.Error("signed headers error", req.SignedHeaders)
+			}
+		})
+	}
+}
+
+func TestPresignPutObjectInvalid(t *testing.T) {
+	presigner := newTestPresigner(SignatureVersionV4)
+	for name, options := range map[string]*PresignOptions{
+		"header":       {Headers: map[string]string{"Cache-Control": "no-cache"}},
+		"expires":      {Expires: 8 * 24 * time.Hour},
+		"callback var": {CallbackVars: map[string]string{"user_id": "123"}},
+	} {
+		if _, err := presigner.PresignPutObject("image.jpg", options); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	if _, err := presigner.PresignPutObject("/image.jpg", nil); err == nil {
+		t.Error("invalid key")
+	}
+
+	presigner = NewPresigner(&Config{
+		AccessKeyId:     "yourAccessKeyId",
+		AccessKeySecret: "yourAccessKeySecret",
+		Host:            "https://static.example.com",
+	})
+	if _, err := presigner.PresignPutObject("image.jpg", nil); err == nil {
+		t.Error("missing bucket")
+	}
+}
+
+func TestPresignGetObject(t *testing.T) {
+	options := &GetObjectOptions{
+		Expires:                    time.Hour,
+		ResponseContentDisposition: `attachment; filename="image 1.jpg"`,
+		ResponseContentType:        "image/jpeg",
+		VersionId:                  "CAEQNhiBgM0BYiIDc4MGZjZGI2OTBjOTRmNTE5NmU5NmFhZjhjYmY0****",
+	}
+
+	for signatureVersion, expectUrl := range map[string]string{
+		SignatureVersionV1: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/image%201.jpg?Expires=1735692600&OSSAccessKeyId=STS.yourAccessKeyId&Signature=Z7SVu5gUVTVxDgd%2FKSq3ilvCQ4Q%3D&response-content-disposition=attachment%3B%20filename%3D%22image%201.jpg%22&response-content-type=image%2Fjpeg&security-token=yourSecurityToken&versionId=CAEQNhiBgM0BYiIDc4MGZjZGI2OTBjOTRmNTE5NmU5NmFhZjhjYmY0%2A%2A%2A%2A",
+		SignatureVersionV4: "https://bucket-name.oss-cn-hangzhou.aliyuncs.com/user-dir-prefix/image%201.jpg?response-content-disposition=attachment%3B%20filename%3D%22image%201.jpg%22&response-content-type=image%2Fjpeg&versionId=CAEQNhiBgM0BYiIDc4MGZjZGI2OTBjOTRmNTE5NmU5NmFhZjhjYmY0%2A%2A%2A%2A&x-oss-credential=STS.yourAccessKeyId%2F20241231%2Fcn-hangzhou%2Foss%2Faliyun_v4_request&x-oss-date=20241231T235000Z&x-oss-expires=3600&x-oss-security-token=yourSecurityToken&x-oss-signature=499b05f27f71b25f8fe2d49da498584ae36ca5a742879b08e83950b17d07701a&x-oss-signature-version=OSS4-HMAC-SHA256",
+	} {
+		t.Run(signatureVersion, func(t *testing.T) {
+			presigner := NewPresigner(&Config{
+				AccessKeyId:      "STS.yourAccessKeyId",
+				AccessKeySecret:  "yourAccessKeySecret",
+				SecurityToken:    "yourSecurityToken",
+				Host:             "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+				SignatureVersion: signatureVersion,
+			})
+			presigner.now = newTestPresigner(signatureVersion).now
+			req, err := presigner.PresignGetObject("user-dir-prefix/image 1.jpg", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL != expectUrl {
+				t.Errorf("expect %s, got %s", expectUrl, req.URL)
+			}
+			if req.Method != "GET" || req.Expire != 1735692600 || req.SignedHeaders != nil {
+				t.Error("presigned request error", req)
+			}
+		})
+	}
+}
+
+func TestPresignGetObjectInvalid(t *testing.T) {
+	presigner := NewPresigner(&Config{
+		AccessKeyId:             "STS.yourAccessKeyId",
+		AccessKeySecret:         "yourAccessKeySecret",
+		SecurityToken:           "yourSecurityToken",
+		SecurityTokenExpiration: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Host:                    "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+	})
+	presigner.now = newTestPresigner(SignatureVersionV1).now
+	if _, err := presigner.PresignGetObject("image.jpg", &GetObjectOptions{Expires: time.Hour}); !errors.Is(err, ErrOutlivesSecurityToken) {
+		t.Error("security token expiration error", err)
+	}
+	if _, err := presigner.PresignGetObject("image.jpg", &GetObjectOptions{Expires: 10 * time.Minute}); err != nil {
+		t.Error(err)
+	}
+	if _, err := presigner.PresignGetObject("", nil); err == nil {
+		t.Error("invalid key")
+	}
+}
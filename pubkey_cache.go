@@ -0,0 +1,153 @@
+package appserver
+
+import (
+	"context"
+	"crypto/rsa"
+	"sync"
+	"time"
+)
+
+const DefaultPublicKeyCacheTTL = time.Hour
+
+// DefaultPublicKeyCache : shared by the verifiers of NewCallbackVerifier and AliyunOSSCallback
+var DefaultPublicKeyCache = NewPublicKeyCache(DefaultPublicKeyCacheTTL)
+
+// PublicKeyCacheStats : Misses counts the fetches, concurrent misses of one url share a fetch;
+// StaleHits counts the last good keys served after a failed refresh
+type PublicKeyCacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	FetchErrors uint64 `json:"fetch_errors"`
+	StaleHits   uint64 `json:"stale_hits"`
+}
+
+// PublicKeyCache : parsed callback public keys per pub key url
+type PublicKeyCache struct {
+	// TTL : default: 1h
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*publicKeyEntry
+	stats   PublicKeyCacheStats
+	now     func() time.Time
+}
+
+type publicKeyEntry struct {
+	key       *rsa.PublicKey
+	fetchedAt time.Time
+	call      *publicKeyCall
+}
+
+// publicKeyCall : the fetch in flight, waiters block on done
+type publicKeyCall struct {
+	done chan struct{}
+	key  *rsa.PublicKey
+	err  error
+}
+
+func NewPublicKeyCache(ttl time.Duration) *PublicKeyCache {
+	return &PublicKeyCache{
+		TTL:     ttl,
+		entries: make(map[string]*publicKeyEntry),
+		now:     time.Now,
+	}
+}
+
+// Get : the cached key of publicKeyURL, fetch is called on a miss or once the TTL has passed
+func (c *PublicKeyCache) Get(publicKeyURL string, fetch func() ([]byte, error)) (*rsa.PublicKey, error) {
+	return c.GetContext(context.Background(), publicKeyURL, fetch)
+}
+
+// GetContext : Get, the caller gives up when ctx is done while the fetch goes on for the other callers,
+// so fetch must not depend on the context of any one caller
+func (c *PublicKeyCache) GetContext(ctx context.Context, publicKeyURL string, fetch func() ([]byte, error)) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*publicKeyEntry)
+	}
+	entry, ok := c.entries[publicKeyURL]
+	if !ok {
+		entry = new(publicKeyEntry)
+		c.entries[publicKeyURL] = entry
+	}
+	if entry.key != nil && c.clock().Sub(entry.fetchedAt) < c.ttl() {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry.key, nil
+	}
+	call := entry.call
+	if call == nil {
+		call = &publicKeyCall{done: make(chan struct{})}
+		entry.call = call
+		c.stats.Misses++
+		go c.fetch(entry, call, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.key, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch : the shared fetch of an entry, the last good key is kept when it fails
+func (c *PublicKeyCache) fetch(entry *publicKeyEntry, call *publicKeyCall, fetch func() ([]byte, error)) {
+	key, err := fetchPublicKey(fetch)
+
+	c.mu.Lock()
+	entry.call = nil
+	if err != nil {
+		c.stats.FetchErrors++
+		if entry.key != nil {
+			c.stats.StaleHits++
+			key, err = entry.key, nil
+		}
+	} else {
+		entry.key = key
+		entry.fetchedAt = c.clock()
+	}
+	call.key, call.err = key, err
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// Purge : drop every cached key, e.g. after a key rotation
+func (c *PublicKeyCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for url, entry := range c.entries {
+		if entry.call == nil {
+			delete(c.entries, url)
+		}
+	}
+}
+
+func (c *PublicKeyCache) Stats() PublicKeyCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *PublicKeyCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultPublicKeyCacheTTL
+}
+
+func (c *PublicKeyCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func fetchPublicKey(fetch func() ([]byte, error)) (*rsa.PublicKey, error) {
+	bytePublicKey, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(bytePublicKey)
+}
@@ -0,0 +1,120 @@
+package appserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPublicKeyPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestPublicKeyCache(t *testing.T) {
+	pemV1 := newTestPublicKeyPEM(t)
+	pemV2 := newTestPublicKeyPEM(t)
+	now := time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	cache := NewPublicKeyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	var fetches int
+	fetchErr := errors.New("cdn is down")
+	var fetchPem []byte
+	fetch := func() ([]byte, error) {
+		fetches++
+		if fetchPem == nil {
+			return nil, fetchErr
+		}
+		return fetchPem, nil
+	}
+
+	if _, err := cache.Get(testPubKeyURL, fetch); !errors.Is(err, fetchErr) {
+		t.Error("fetch error", err)
+	}
+
+	fetchPem = pemV1
+	keyV1, err := cache.Get(testPubKeyURL, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := cache.Get(testPubKeyURL, fetch); key != keyV1 || fetches != 2 {
+		t.Error("cached key error", fetches)
+	}
+
+	// expired, the refresh fails and the last good key is served
+	now = now.Add(2 * time.Minute)
+	fetchPem = nil
+	if key, err := cache.Get(testPubKeyURL, fetch); err != nil || key != keyV1 {
+		t.Error("stale key error", err)
+	}
+
+	fetchPem = pemV2
+	keyV2, err := cache.Get(testPubKeyURL, fetch)
+	if err != nil || keyV2.Equal(keyV1) {
+		t.Error("refreshed key error", err)
+	}
+
+	fetchPem = []byte("not a pem")
+	cache.Purge()
+	if _, err := cache.Get(testPubKeyURL, fetch); err == nil {
+		t.Error("invalid pem error")
+	}
+
+	expect := PublicKeyCacheStats{Hits: 1, Misses: 5, FetchErrors: 3, StaleHits: 1}
+	if stats := cache.Stats(); stats != expect {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+}
+
+func TestPublicKeyCacheCoalesce(t *testing.T) {
+	pemV1 := newTestPublicKeyPEM(t)
+	cache := NewPublicKeyCache(0)
+
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() ([]byte, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return pemV1, nil
+	}
+
+	var wg sync.WaitGroup
+	keys := make([]*rsa.PublicKey, 10)
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], _ = cache.Get(testPubKeyURL, fetch)
+		}(i)
+	}
+	// let the goroutines pile up behind the first fetch
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if atomic.LoadInt32(&fetches) != 1 {
+		t.Error("fetches must be coalesced", fetches)
+	}
+	for _, key := range keys {
+		if key == nil || key != keys[0] {
+			t.Error("coalesced key error")
+		}
+	}
+}
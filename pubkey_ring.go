@@ -0,0 +1,94 @@
+package appserver
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CallbackPublicKeyV1 : the OSS callback public key at https://gosspublic.alicdn.com/callback_pub_key_v1.pem
+const CallbackPublicKeyV1 = "-----BEGIN PUBLIC KEY-----\nMFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAKs/JBGzwUB2aVht4crBx3oIPBLNsjGs\nC0fTXv+nvlmklvkcolvpvXLTjaxUHR3W9LXxQ2EHXAJfCB+6H2YF1k8CAwEAAQ==\n-----END PUBLIC KEY-----"
+
+// PublicKeyRing : pinned callback public keys per pub key url, a url holds the old and the new key
+// while a key is rotated; safe for concurrent use
+type PublicKeyRing struct {
+	mu   sync.RWMutex
+	keys map[string][]*rsa.PublicKey
+}
+
+func NewPublicKeyRing() *PublicKeyRing {
+	return &PublicKeyRing{keys: make(map[string][]*rsa.PublicKey)}
+}
+
+// NewDefaultPublicKeyRing : the embedded OSS callback public keys
+func NewDefaultPublicKeyRing() *PublicKeyRing {
+	ring := NewPublicKeyRing()
+	if err := ring.AddPEM(DefaultPublicKeyURLs[0], []byte(CallbackPublicKeyV1)); err != nil {
+		panic(err)
+	}
+	return ring
+}
+
+func (r *PublicKeyRing) Add(publicKeyURL string, key *rsa.PublicKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys == nil {
+		r.keys = make(map[string][]*rsa.PublicKey)
+	}
+	for _, k := range r.keys[publicKeyURL] {
+		if k.Equal(key) {
+			return
+		}
+	}
+	r.keys[publicKeyURL] = append(r.keys[publicKeyURL], key)
+}
+
+func (r *PublicKeyRing) AddPEM(publicKeyURL string, bytePublicKey []byte) error {
+	key, err := ParsePublicKey(bytePublicKey)
+	if err != nil {
+		return err
+	}
+	r.Add(publicKeyURL, key)
+	return nil
+}
+
+func (r *PublicKeyRing) AddFile(publicKeyURL string, path string) error {
+	bytePublicKey, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = r.AddPEM(publicKeyURL, bytePublicKey); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Remove : drop a key once the rotation is over
+func (r *PublicKeyRing) Remove(publicKeyURL string, key *rsa.PublicKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := r.keys[publicKeyURL][:0]
+	for _, k := range r.keys[publicKeyURL] {
+		if !k.Equal(key) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		delete(r.keys, publicKeyURL)
+		return
+	}
+	r.keys[publicKeyURL] = keys
+}
+
+// Keys : the pinned keys of publicKeyURL, http is looked up as https
+func (r *PublicKeyRing) Keys(publicKeyURL string) ([]*rsa.PublicKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, candidate := range publicKeyURLCandidates(publicKeyURL) {
+		if keys := r.keys[candidate]; len(keys) > 0 {
+			return append([]*rsa.PublicKey(nil), keys...), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrPublicKeyNotPinned, publicKeyURL)
+}
@@ -0,0 +1,77 @@
+package appserver
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestDefaultPublicKeyRing(t *testing.T) {
+	ring := NewDefaultPublicKeyRing()
+	for _, publicKeyURL := range []string{
+		"https://gosspublic.alicdn.com/callback_pub_key_v1.pem",
+		"http://gosspublic.alicdn.com/callback_pub_key_v1.pem",
+	} {
+		if keys, err := ring.Keys(publicKeyURL); err != nil || len(keys) != 1 {
+			t.Error(publicKeyURL, "pinned key error", err)
+		}
+	}
+	if _, err := ring.Keys("https://gosspublic.alicdn.com/callback_pub_key_v3.pem"); !errors.Is(err, ErrPublicKeyNotPinned) {
+		t.Error("not pinned error", err)
+	}
+}
+
+func TestCallbackVerifierPinnedPublicKeys(t *testing.T) {
+	oldSigner := newTestCallbackSigner(t)
+	newSigner := newTestCallbackSigner(t)
+	// nothing is served, pinned keys must not touch the network
+	httpmock.Reset()
+
+	ring := NewPublicKeyRing()
+	if err := ring.AddPEM(testPubKeyURL, oldSigner.pem); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "callback_pub_key_v1.pem")
+	if err := os.WriteFile(path, newSigner.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddFile(testPubKeyURL, path); err != nil {
+		t.Fatal(err)
+	}
+	verifier := &CallbackVerifier{PinnedPublicKeys: ring}
+
+	for name, signer := range map[string]*testCallbackSigner{"old": oldSigner, "new": newSigner} {
+		req := signer.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+		if _, err := verifier.Verify(req.URL.EscapedPath(), req.URL.RawQuery, req.Header, []byte(testCallbackBody)); err != nil {
+			t.Errorf("%s key: %v", name, err)
+		}
+	}
+
+	// rotation is over
+	oldKey, _ := ParsePublicKey(oldSigner.pem)
+	ring.Remove(testPubKeyURL, oldKey)
+	req := oldSigner.request(t, "/oss/callback", CallbackBodyTypeParam, testCallbackBody)
+	if _, err := verifier.Verify(req.URL.EscapedPath(), req.URL.RawQuery, req.Header, []byte(testCallbackBody)); !errors.Is(err, rsa.ErrVerification) {
+		t.Error("removed key error", err)
+	}
+
+	req.Header.Set(PubKeyUrlHeader, base64.StdEncoding.EncodeToString([]byte("https://gosspublic.alicdn.com/callback_pub_key_v2.pem")))
+	if _, err := verifier.Verify(req.URL.EscapedPath(), req.URL.RawQuery, req.Header, []byte(testCallbackBody)); !errors.Is(err, ErrPublicKeyNotPinned) {
+		t.Error("not pinned error", err)
+	}
+	// the default allowlist and the default pinned keys agree
+	defaultRing := NewDefaultPublicKeyRing()
+	for _, publicKeyURL := range DefaultPublicKeyURLs {
+		if _, err := defaultRing.Keys(publicKeyURL); err != nil {
+			t.Error("default public key url not pinned", publicKeyURL, err)
+		}
+	}
+	if err := ring.AddPEM(testPubKeyURL, []byte("not a pem")); err == nil {
+		t.Error("invalid pem error")
+	}
+}
@@ -0,0 +1,100 @@
+package appserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const SignatureVersionV1 = "v1"
+const SignatureVersionV4 = "v4"
+
+const SignatureAlgorithmV4 = "OSS4-HMAC-SHA256"
+const TimeISO8601Basic = "20060102T150405Z"
+const TimeDateBasic = "20060102"
+
+// signV1 : base64(hmac-sha1(secret, content))
+// https://help.aliyun.com/zh/oss/developer-reference/signature-version-1
+func signV1(accessKeySecret string, content string) string {
+	h := hmac.New(sha1.New, []byte(accessKeySecret))
+	h.Write([]byte(content))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// signV4 : hex(hmac-sha256(signingKey, content))
+// https://help.aliyun.com/zh/oss/developer-reference/recommend-to-use-signature-version-4
+func signV4(accessKeySecret string, signedAt time.Time, region string, content string) string {
+	h := hmac.New(sha256.New, signingKeyV4(accessKeySecret, signedAt, region))
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signingKeyV4 : derive the date/region scoped signing key
+func signingKeyV4(accessKeySecret string, signedAt time.Time, region string) []byte {
+	key := hmacSHA256([]byte("aliyun_v4"+accessKeySecret), signedAt.UTC().Format(TimeDateBasic))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "oss")
+	return hmacSHA256(key, "aliyun_v4_request")
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(content))
+	return h.Sum(nil)
+}
+
+// credentialScopeV4 : <date>/<region>/oss/aliyun_v4_request
+func credentialScopeV4(signedAt time.Time, region string) string {
+	return fmt.Sprintf("%s/%s/oss/aliyun_v4_request", signedAt.UTC().Format(TimeDateBasic), region)
+}
+
+// regionFromHost : cn-hangzhou from https://bucket-name.oss-cn-hangzhou.aliyuncs.com
+func regionFromHost(host string) string {
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !strings.HasPrefix(label, "oss-") {
+			continue
+		}
+		region := strings.TrimSuffix(strings.TrimPrefix(label, "oss-"), "-internal")
+		if region == "accelerate" || region == "accelerate-overseas" {
+			return ""
+		}
+		return region
+	}
+	return ""
+}
+
+// bucketFromHost : bucket-name from https://bucket-name.oss-cn-hangzhou.aliyuncs.com
+func bucketFromHost(host string) string {
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 || !strings.HasPrefix(labels[1], "oss-") {
+		return ""
+	}
+	return labels[0]
+}
+
+// uriEncode : RFC 3986 percent encoding, unreserved characters are kept, "/" too when keepSlash
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
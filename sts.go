@@ -0,0 +1,242 @@
+package appserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const STSVersion = "2015-04-01"
+const DefaultAssumeRoleDurationSeconds = 3600
+
+// AssumeRoleRequest
+// https://help.aliyun.com/zh/ram/developer-reference/api-sts-2015-04-01-assumerole
+type AssumeRoleRequest struct {
+	RoleArn         string `json:"role_arn"`          // required
+	RoleSessionName string `json:"role_session_name"` // required
+	Policy          string `json:"policy"`            // optional, see NewDirectorySessionPolicy
+	DurationSeconds int64  `json:"duration_seconds"`  // optional, default: 3600
+}
+
+func (r *AssumeRoleRequest) Validate() error {
+	if r.RoleArn == "" {
+		return configErrorf("role_arn", "missing required RoleArn")
+	}
+	if r.RoleSessionName == "" {
+		return configErrorf("role_session_name", "missing required RoleSessionName")
+	}
+	return nil
+}
+
+func (r *AssumeRoleRequest) cacheKey() string {
+	return strings.Join([]string{r.RoleArn, r.RoleSessionName, r.Policy, strconv.FormatInt(r.DurationSeconds, 10)}, "\n")
+}
+
+// STSClient : AssumeRole with the RAM user credentials of the appserver, role credentials are cached per request
+type STSClient struct {
+	// Endpoint : default: https://sts.aliyuncs.com
+	Endpoint string
+	Client   *http.Client
+	// RefreshBefore : optional, default: 15m, at least the longest ExpireSecond and presign Expires in use
+	RefreshBefore time.Duration
+
+	credentials CredentialsProvider
+
+	mu     sync.Mutex
+	caches map[string]*credentialsCache
+}
+
+func NewSTSClient(credentials CredentialsProvider) *STSClient {
+	return &STSClient{
+		Endpoint:    DefaultSTSEndpoint,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		credentials: credentials,
+		caches:      make(map[string]*credentialsCache),
+	}
+}
+
+// AssumeRole : cached role credentials, refreshed before they expire
+func (c *STSClient) AssumeRole(req *AssumeRoleRequest) (*Credentials, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	request := *req
+	return c.cache(request.cacheKey()).get(c.RefreshBefore, func() (*Credentials, error) {
+		return c.assumeRole(&request)
+	})
+}
+
+// NewAssumeRoleCredentialsProvider : use the role credentials as Config.CredentialsProvider
+func (c *STSClient) NewAssumeRoleCredentialsProvider(req *AssumeRoleRequest) CredentialsProvider {
+	request := *req
+	return CredentialsProviderFunc(func() (*Credentials, error) {
+		return c.AssumeRole(&request)
+	})
+}
+
+// cache : one cache per request, expired ones are dropped so per-user sessions do not pile up
+func (c *STSClient) cache(key string) *credentialsCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.caches == nil {
+		c.caches = make(map[string]*credentialsCache)
+	}
+	if cache, ok := c.caches[key]; ok {
+		return cache
+	}
+	for k, cache := range c.caches {
+		cache.mu.Lock()
+		expired := cache.credentials != nil && cache.credentials.expiresWithin(0)
+		cache.mu.Unlock()
+		if expired {
+			delete(c.caches, k)
+		}
+	}
+	cache := new(credentialsCache)
+	c.caches[key] = cache
+	return cache
+}
+
+func (c *STSClient) assumeRole(req *AssumeRoleRequest) (*Credentials, error) {
+	if c.credentials == nil {
+		return nil, configErrorf("credentials", "assume role: missing credentials provider")
+	}
+	credentials, err := c.credentials.GetCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("assume role: %w", err)
+	}
+
+	durationSeconds := req.DurationSeconds
+	if durationSeconds == 0 {
+		durationSeconds = DefaultAssumeRoleDurationSeconds
+	}
+	params := map[string]string{
+		"Action":          "AssumeRole",
+		"RoleArn":         req.RoleArn,
+		"RoleSessionName": req.RoleSessionName,
+		"DurationSeconds": strconv.FormatInt(durationSeconds, 10),
+	}
+	if req.Policy != "" {
+		params["Policy"] = req.Policy
+	}
+	form, err := signRPCRequest(http.MethodPost, params, credentials, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("assume role: %w", err)
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	roleCredentials, err := doSTSRequest(httpClient(c.Client), httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("assume role: %w", err)
+	}
+	return roleCredentials, nil
+}
+
+// signRPCRequest : add the common parameters and the signature of an RPC style API call
+// https://help.aliyun.com/zh/sdk/product-overview/rpc-mechanism
+func signRPCRequest(method string, params map[string]string, credentials *Credentials, signedAt time.Time) (url.Values, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	values.Set("Format", "JSON")
+	values.Set("Version", STSVersion)
+	values.Set("AccessKeyId", credentials.AccessKeyId)
+	values.Set("SignatureMethod", "HMAC-SHA1")
+	values.Set("SignatureVersion", "1.0")
+	values.Set("SignatureNonce", hex.EncodeToString(nonce))
+	values.Set("Timestamp", signedAt.UTC().Format(TimeGMTISO8601))
+	if credentials.SecurityToken != "" {
+		values.Set("SecurityToken", credentials.SecurityToken)
+	}
+	values.Set("Signature", signV1(credentials.AccessKeySecret+"&", rpcStringToSign(method, values)))
+	return values, nil
+}
+
+// rpcStringToSign : METHOD&%2F&percentEncode(sorted canonicalized query)
+func rpcStringToSign(method string, values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, rpcPercentEncode(k)+"="+rpcPercentEncode(values.Get(k)))
+	}
+	return method + "&" + rpcPercentEncode("/") + "&" + rpcPercentEncode(strings.Join(pairs, "&"))
+}
+
+func rpcPercentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+// RAMPolicy
+// https://help.aliyun.com/zh/ram/user-guide/policy-structure-and-syntax
+type RAMPolicy struct {
+	Version   string               `json:"Version"`
+	Statement []RAMPolicyStatement `json:"Statement"`
+}
+
+type RAMPolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// DirectorySessionActions : simple, form and multipart uploads
+var DirectorySessionActions = []string{"oss:PutObject", "oss:AbortMultipartUpload", "oss:ListParts"}
+
+// NewDirectorySessionPolicy : session policy limited to the same directory prefix Policy.SetDirectory enforces,
+// actions default to DirectorySessionActions
+func NewDirectorySessionPolicy(bucket string, directory string, actions ...string) (string, error) {
+	if bucket == "" {
+		return "", configErrorf("bucket", "missing required bucket")
+	}
+	if strings.ContainsAny(bucket+directory, "*?") {
+		return "", configErrorf("directory", "invalid wildcard in bucket or directory")
+	}
+	if len(actions) == 0 {
+		actions = DirectorySessionActions
+	}
+
+	policy := RAMPolicy{
+		Version: "1",
+		Statement: []RAMPolicyStatement{{
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: []string{fmt.Sprintf("acs:oss:*:*:%s/%s*", bucket, directory)},
+		}},
+	}
+	policyByte, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	return string(policyByte), nil
+}
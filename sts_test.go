@@ -0,0 +1,119 @@
+package appserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRPCStringToSign(t *testing.T) {
+	values := url.Values{}
+	values.Set("AccessKeyId", "testid")
+	values.Set("Action", "DescribeRegions")
+	values.Set("Format", "XML")
+	values.Set("SignatureMethod", "HMAC-SHA1")
+	values.Set("SignatureNonce", "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf")
+	values.Set("SignatureVersion", "1.0")
+	values.Set("Timestamp", "2016-02-23T12:46:24Z")
+	values.Set("Version", "2014-05-26")
+
+	stringToSign := rpcStringToSign(http.MethodGet, values)
+	expectStringToSign := "GET&%2F&AccessKeyId%3Dtestid%26Action%3DDescribeRegions%26Format%3DXML%26SignatureMethod%3DHMAC-SHA1%26SignatureNonce%3D3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf%26SignatureVersion%3D1.0%26Timestamp%3D2016-02-23T12%253A46%253A24Z%26Version%3D2014-05-26"
+	if stringToSign != expectStringToSign {
+		t.Errorf("expect %s, got %s", expectStringToSign, stringToSign)
+	}
+	if signature := signV1("testsecret&", stringToSign); signature != "OLeaidS1JvxuMvnyHOwuJ+uX5qY=" {
+		t.Errorf("signature error, got %s", signature)
+	}
+}
+
+func newSTSServer(t *testing.T, assumed *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		signature := form.Get("Signature")
+		form.Del("Signature")
+		if form.Get("AccessKeyId") != "yourAccessKeyId" || signV1("yourAccessKeySecret&", rpcStringToSign(r.Method, form)) != signature {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"Code":"SignatureDoesNotMatch"}`))
+			return
+		}
+		if form.Get("Action") != "AssumeRole" || form.Get("Version") != STSVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(assumed, 1)
+		_, _ = fmt.Fprintf(w, `{"RequestId":"1","Credentials":{"AccessKeyId":"STS.%s.%d","AccessKeySecret":"roleAccessKeySecret","SecurityToken":"roleSecurityToken","Expiration":"%s"}}`,
+			form.Get("RoleSessionName"), n, time.Now().Add(time.Hour).UTC().Format(TimeGMTISO8601))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSTSClientAssumeRole(t *testing.T) {
+	var assumed int32
+	server := newSTSServer(t, &assumed)
+	client := NewSTSClient(NewStaticCredentialsProvider("yourAccessKeyId", "yourAccessKeySecret", ""))
+	client.Endpoint = server.URL
+
+	policy, err := NewDirectorySessionPolicy("bucket-name", "user-1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &AssumeRoleRequest{RoleArn: "acs:ram::123456:role/upload", RoleSessionName: "user-1", Policy: policy}
+	for i := 0; i < 2; i++ {
+		credentials, err := client.AssumeRole(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credentials.AccessKeyId != "STS.user-1.1" || credentials.SecurityToken != "roleSecurityToken" {
+			t.Error("credentials error", credentials)
+		}
+	}
+	if assumed != 1 {
+		t.Errorf("expect 1 assume role, got %d", assumed)
+	}
+
+	token := NewToken(&Config{
+		Host:                "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		CredentialsProvider: client.NewAssumeRoleCredentialsProvider(&AssumeRoleRequest{RoleArn: "acs:ram::123456:role/upload", RoleSessionName: "user-2"}),
+	})
+	tokenPayload, err := token.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenPayload.OSSAccessKeyId != "STS.user-2.2" || tokenPayload.SecurityToken != "roleSecurityToken" {
+		t.Error("token error", tokenPayload)
+	}
+
+	client = NewSTSClient(NewStaticCredentialsProvider("yourAccessKeyId", "wrongAccessKeySecret", ""))
+	client.Endpoint = server.URL
+	if _, err = client.AssumeRole(req); err == nil {
+		t.Error("wrong signature")
+	}
+}
+
+func TestNewDirectorySessionPolicy(t *testing.T) {
+	policy, err := NewDirectorySessionPolicy("bucket-name", "user-dir-prefix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPolicy := `{"Version":"1","Statement":[{"Effect":"Allow","Action":["oss:PutObject","oss:AbortMultipartUpload","oss:ListParts"],"Resource":["acs:oss:*:*:bucket-name/user-dir-prefix/*"]}]}`
+	if policy != expectPolicy {
+		t.Errorf("expect %s, got %s", expectPolicy, policy)
+	}
+
+	if _, err = NewDirectorySessionPolicy("bucket-name", "user-*/"); err == nil {
+		t.Error("wildcard directory")
+	}
+	if _, err = NewDirectorySessionPolicy("", "user-dir-prefix/"); err == nil {
+		t.Error("empty bucket")
+	}
+}
@@ -1,25 +1,32 @@
 package appserver
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"hash"
-	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const TimeGMTISO8601 = "2006-01-02T15:04:05Z"
 const CallbackBodyParam = `{"bucket":${bucket},"object":${object},"etag":${etag},"size":${size},"mimeType":${mimeType},"imageInfo":{"height":${imageInfo.height},"width":${imageInfo.width},"format":${imageInfo.format}},"crc64":${crc64},"contentMd5":${contentMd5},"vpcId":${vpcId},"clientIp":${clientIp},"reqId":${reqId},"operation":${operation}}`
+const CallbackBodyFormParam = `bucket=${bucket}&object=${object}&etag=${etag}&size=${size}&mimeType=${mimeType}&imageInfo.height=${imageInfo.height}&imageInfo.width=${imageInfo.width}&imageInfo.format=${imageInfo.format}&crc64=${crc64}&contentMd5=${contentMd5}&vpcId=${vpcId}&clientIp=${clientIp}&reqId=${reqId}&operation=${operation}`
 const CallbackBodyTypeParam = "application/json"
+const CallbackBodyTypeForm = "application/x-www-form-urlencoded"
 const DefaultExpireSecond = 600
+const MaxCallbackUrls = 5
 
 type Token struct {
-	config   *Config
-	policy   *Policy
-	callback *Callback
+	config       *Config
+	policy       *Policy
+	callback     *Callback
+	keyGenerator KeyGenerator
+	keyInput     *KeyInput
+	callbackVars map[string]string
+	now          func() time.Time
 }
 
 func NewToken(config *Config) *Token {
@@ -30,6 +37,7 @@ func NewToken(config *Config) *Token {
 	return &Token{
 		config:   config,
 		callback: callback,
+		now:      time.Now,
 	}
 }
 
@@ -52,18 +60,22 @@ func newPolicy(config *Config) *Policy {
 func newCallback(config *Config) *Callback {
 	cp := new(Callback)
 	cp.CallbackUrl = config.CallbackUrl
-
-	if config.CallbackBody != "" {
-		cp.CallbackBody = config.CallbackBody
-	} else {
-		cp.CallbackBody = CallbackBodyParam
-	}
+	cp.CallbackHost = config.CallbackHost
+	cp.CallbackSNI = config.CallbackSNI
 
 	if config.CallbackBodyType != "" {
 		cp.CallbackBodyType = config.CallbackBodyType
 	} else {
 		cp.CallbackBodyType = CallbackBodyTypeParam
 	}
+
+	if config.CallbackBody != "" {
+		cp.CallbackBody = config.CallbackBody
+	} else if cp.CallbackBodyType == CallbackBodyTypeForm {
+		cp.CallbackBody = CallbackBodyFormParam
+	} else {
+		cp.CallbackBody = CallbackBodyParam
+	}
 	return cp
 }
 
@@ -79,6 +91,37 @@ func (t *Token) SetCallback(callback *Callback) *Token {
 	return &k
 }
 
+// SetKeyGenerator : resolve the full object key, instead of Config.KeyTemplate
+func (t *Token) SetKeyGenerator(keyGenerator KeyGenerator) *Token {
+	k := *t
+	k.keyGenerator = keyGenerator
+	return &k
+}
+
+// SetKeyInput : file name and user the object key is generated from
+func (t *Token) SetKeyInput(keyInput *KeyInput) *Token {
+	k := *t
+	k.keyInput = keyInput
+	return &k
+}
+
+// SetCallbackVars : custom callback vars, "user_id" or "x:user_id", wired into the callback body
+func (t *Token) SetCallbackVars(vars map[string]string) *Token {
+	k := *t
+	k.callbackVars = vars
+	return &k
+}
+
+func (t *Token) getKeyGenerator() (KeyGenerator, error) {
+	if t.keyGenerator != nil {
+		return t.keyGenerator, nil
+	}
+	if t.config.KeyTemplate != "" {
+		return NewKeyTemplate(t.config.KeyTemplate)
+	}
+	return nil, nil
+}
+
 func (t *Token) Generate() (*SignatureToken, error) {
 	// policy
 	var policy *Policy
@@ -87,40 +130,105 @@ func (t *Token) Generate() (*SignatureToken, error) {
 	} else {
 		policy = newPolicy(t.config)
 	}
+	signedAt := t.now()
+	var conditions []any
+
+	// key
+	keyGenerator, err := t.getKeyGenerator()
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	if keyGenerator != nil {
+		keyInput := KeyInput{Time: signedAt}
+		if t.keyInput != nil {
+			keyInput = *t.keyInput
+			if keyInput.Time.IsZero() {
+				keyInput.Time = signedAt
+			}
+		}
+		name, err := keyGenerator.GenerateKey(&keyInput)
+		if err != nil {
+			return nil, err
+		}
+		key = policy.GetDirectory() + name
+		if err = ValidateObjectKey(key); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, []any{PolicyConditionEq, "$key", key})
+	}
+
+	// credentials
+	credentials, err := t.config.credentialsProvider().GetCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if credentials.SecurityToken != "" {
+		if exp := credentials.Expiration; !exp.IsZero() && policy.expireTime().After(exp) {
+			return nil, fmt.Errorf("%w: policy expiration %s, security token expiration %s", ErrOutlivesSecurityToken,
+				policy.Expiration, exp.UTC().Format(TimeGMTISO8601))
+		}
+		conditions = append(conditions, map[string]string{"x-oss-security-token": credentials.SecurityToken})
+	}
+
+	// signature version
+	var region string
+	var credential string
+	if t.config.SignatureVersion == SignatureVersionV4 {
+		region = t.config.signRegion()
+		if region == "" {
+			return nil, configErrorf("region", "missing required config region for signature version v4")
+		}
+		credential = credentials.AccessKeyId + "/" + credentialScopeV4(signedAt, region)
+
+		conditions = append(conditions,
+			map[string]string{"x-oss-signature-version": SignatureAlgorithmV4},
+			map[string]string{"x-oss-credential": credential},
+			map[string]string{"x-oss-date": signedAt.UTC().Format(TimeISO8601Basic)},
+		)
+	}
+	if len(conditions) > 0 {
+		policy = policy.withConditions(conditions...)
+	}
+
 	policyByte, err := json.Marshal(policy)
 	if err != nil {
 		return nil, err
 	}
 	policyBas64 := base64.StdEncoding.EncodeToString(policyByte)
 
-	// signature
-	h := hmac.New(func() hash.Hash { return sha1.New() }, []byte(t.config.AccessKeySecret))
-	_, err = io.WriteString(h, policyBas64)
+	// callback, PostObject takes the vars as x: form fields rather than callback-var
+	callbackBase64, _, err := encodeCallback(t.callback, t.callbackVars)
 	if err != nil {
 		return nil, err
 	}
-	signatureBase64 := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	// callback
-	var callbackBase64 string
-	if t.callback != nil && t.callback.Validate() == nil {
-		var callbackStr []byte
-		callbackStr, err = json.Marshal(t.callback)
-		if err != nil {
+	var callbackVars map[string]string
+	if len(t.callbackVars) > 0 {
+		if callbackVars, err = normalizeCallbackVars(t.callbackVars); err != nil {
 			return nil, err
 		}
-		callbackBase64 = base64.StdEncoding.EncodeToString(callbackStr)
 	}
 
 	// token
 	var policyToken SignatureToken
-	policyToken.OSSAccessKeyId = t.config.AccessKeyId
+	if t.config.SignatureVersion == SignatureVersionV4 {
+		policyToken.SignatureVersion = SignatureAlgorithmV4
+		policyToken.Credential = credential
+		policyToken.Date = signedAt.UTC().Format(TimeISO8601Basic)
+		policyToken.SignatureV4 = signV4(credentials.AccessKeySecret, signedAt, region, policyBas64)
+	} else {
+		policyToken.OSSAccessKeyId = credentials.AccessKeyId
+		policyToken.Signature = signV1(credentials.AccessKeySecret, policyBas64)
+	}
 	policyToken.Host = t.config.Host
 	policyToken.Directory = policy.GetDirectory()
+	policyToken.Key = key
+	policyToken.ContentType = policy.contentType()
 	policyToken.Expire = policy.GetExpire()
-	policyToken.Signature = signatureBase64
 	policyToken.Policy = policyBas64
 	policyToken.Callback = callbackBase64
+	policyToken.CallbackVars = callbackVars
+	policyToken.SecurityToken = credentials.SecurityToken
 
 	return &policyToken, nil
 }
@@ -130,30 +238,88 @@ type Config struct {
 	AccessKeyId     string `json:"access_key_id"`
 	AccessKeySecret string `json:"access_key_secret"`
 	Host            string `json:"host"`
+	Bucket          string `json:"bucket"` // optional, default: parsed from host
+
+	// STS temporary credential
+	SecurityToken           string    `json:"security_token"`
+	SecurityTokenExpiration time.Time `json:"security_token_expiration"` // optional, policy expiration must not outlive it
+
+	// CredentialsProvider : optional, replaces the AccessKey and SecurityToken fields above
+	CredentialsProvider CredentialsProvider `json:"-"`
+
+	// Signature, default: v1
+	SignatureVersion string `json:"signature_version"`
+	Region           string `json:"region"` // v4 only, default: parsed from host
 
 	// Callback
-	CallbackUrl      string `json:"callback_url"`
+	CallbackUrl      string `json:"callback_url"` // up to 5 urls separated by ";", tried in order
+	CallbackHost     string `json:"callback_host"`
+	CallbackSNI      bool   `json:"callback_sni"`
 	CallbackBody     string `json:"callback_body"`
 	CallbackBodyType string `json:"callback_body_type"`
 
 	// Policy
 	Directory    string `json:"directory"`
 	ExpireSecond int64  `json:"expire_second"`
+	KeyTemplate  string `json:"key_template"` // optional, e.g. ${date:2006/01/02}/${uuid}${ext}, see KeyTemplate
+
+	// Batch limits, checked per file by Token.GenerateBatch
+	MaxFileSize   int64    `json:"max_file_size"`   // optional, bytes, 0: no limit
+	ContentTypes  []string `json:"content_types"`   // optional, e.g. image/jpeg or image/*, empty: any type
+	MaxBatchFiles int      `json:"max_batch_files"` // optional, default: 100
 }
 
 func (c *Config) Validate() error {
-	if c.AccessKeyId == "" {
-		return fmt.Errorf("missing required config access_key_id")
+	if c.CredentialsProvider == nil && c.AccessKeyId == "" {
+		return configErrorf("access_key_id", "missing required config access_key_id")
 	}
-	if c.AccessKeySecret == "" {
-		return fmt.Errorf("missing required config access_key_secret")
+	if c.CredentialsProvider == nil && c.AccessKeySecret == "" {
+		return configErrorf("access_key_secret", "missing required config access_key_secret")
 	}
 	if c.Host == "" {
-		return fmt.Errorf("missing required config host")
+		return configErrorf("host", "missing required config host")
+	}
+	switch c.SignatureVersion {
+	case "", SignatureVersionV1:
+	case SignatureVersionV4:
+		if c.signRegion() == "" {
+			return configErrorf("region", "missing required config region")
+		}
+	default:
+		return configErrorf("signature_version", "unsupported config signature_version %q", c.SignatureVersion)
 	}
 	return nil
 }
 
+// credentialsProvider : the configured provider, otherwise the static config fields
+func (c *Config) credentialsProvider() CredentialsProvider {
+	if c.CredentialsProvider != nil {
+		return c.CredentialsProvider
+	}
+	return CredentialsProviderFunc(func() (*Credentials, error) {
+		return &Credentials{
+			AccessKeyId:     c.AccessKeyId,
+			AccessKeySecret: c.AccessKeySecret,
+			SecurityToken:   c.SecurityToken,
+			Expiration:      c.SecurityTokenExpiration,
+		}, nil
+	})
+}
+
+func (c *Config) signBucket() string {
+	if c.Bucket != "" {
+		return c.Bucket
+	}
+	return bucketFromHost(c.Host)
+}
+
+func (c *Config) signRegion() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return regionFromHost(c.Host)
+}
+
 // Policy
 // https://help.aliyun.com/zh/oss/developer-reference/signature-version-1
 type Policy struct {
@@ -168,10 +334,42 @@ func (c *Policy) GetExpire() int64 {
 	return c.expiredAt.Unix()
 }
 
+// expireTime : falls back to the Expiration field when the policy was not built by SetExpireTime
+func (c *Policy) expireTime() time.Time {
+	if !c.expiredAt.IsZero() {
+		return c.expiredAt
+	}
+	expiredAt, _ := time.Parse(TimeGMTISO8601, c.Expiration)
+	return expiredAt
+}
+
 func (c *Policy) GetDirectory() string {
 	return c.uploadDir
 }
 
+// contentType : the value of an ["eq", "$content-type", value] condition, the form has to send it as is
+func (c *Policy) contentType() string {
+	for _, condition := range c.Conditions {
+		if condition, ok := condition.([]any); ok && len(condition) == 3 {
+			field, _ := condition[1].(string)
+			value, _ := condition[2].(string)
+			if condition[0] == PolicyConditionEq && strings.EqualFold(field, "$content-type") {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// withConditions : copy of the policy with extra conditions, the receiver is left untouched
+func (c *Policy) withConditions(conditions ...any) *Policy {
+	k := *c
+	k.Conditions = make([]any, 0, len(c.Conditions)+len(conditions))
+	k.Conditions = append(k.Conditions, c.Conditions...)
+	k.Conditions = append(k.Conditions, conditions...)
+	return &k
+}
+
 func (c *Policy) SetExpireTime(expiredAt time.Time) {
 	c.Expiration = expiredAt.UTC().Format(TimeGMTISO8601)
 	c.expiredAt = expiredAt
@@ -207,31 +405,120 @@ func (c *Policy) SetContentType(types ...string) {
 // https://help.aliyun.com/zh/oss/developer-reference/postobject
 type SignatureToken struct {
 	// post object param
-	OSSAccessKeyId string `json:"OSSAccessKeyId"` // required
-	Policy         string `json:"policy"`         // required
-	Callback       string `json:"callback"`       // optional
-	Signature      string `json:"signature"`      // required
+	OSSAccessKeyId string            `json:"OSSAccessKeyId,omitempty"` // required, v1
+	Policy         string            `json:"policy"`                   // required
+	Callback       string            `json:"callback"`                 // optional
+	CallbackVars   map[string]string `json:"callback_vars,omitempty"`  // optional, sent as one x: form field each
+	Signature      string            `json:"signature,omitempty"`      // required, v1
+	ContentType    string            `json:"Content-Type,omitempty"`   // optional, the eq content-type of the policy
+	// post object param, signature version 4
+	// https://help.aliyun.com/zh/oss/developer-reference/signature-version-4-recommend
+	SignatureVersion string `json:"x-oss-signature-version,omitempty"` // required, v4
+	Credential       string `json:"x-oss-credential,omitempty"`        // required, v4
+	Date             string `json:"x-oss-date,omitempty"`              // required, v4
+	SignatureV4      string `json:"x-oss-signature,omitempty"`         // required, v4
+	// post object param, sts
+	SecurityToken string `json:"x-oss-security-token,omitempty"` // optional
 	// api param
-	Host      string `json:"host"`      // optional
-	Expire    int64  `json:"expire"`    // optional
-	Directory string `json:"directory"` // optional
+	Host      string `json:"host"`          // optional
+	Expire    int64  `json:"expire"`        // optional
+	Directory string `json:"directory"`     // optional
+	Key       string `json:"key,omitempty"` // optional, the exact key when a key generator is set
 
 }
 
 // Callback
 // https://help.aliyun.com/zh/oss/developer-reference/callback
 type Callback struct {
-	CallbackUrl      string `json:"callbackUrl"`                // required
+	CallbackUrl      string `json:"callbackUrl"`                // required, up to 5 urls separated by ";"
+	CallbackHost     string `json:"callbackHost,omitempty"`     // optional, default: host of the callback url
 	CallbackBody     string `json:"callbackBody"`               // optional
+	CallbackSNI      bool   `json:"callbackSNI,omitempty"`      // optional, send the callback host as SNI
 	CallbackBodyType string `json:"callbackBodyType,omitempty"` // optional, default: application/x-www-form-urlencoded
 }
 
+// SetCallbackUrls : OSS tries the urls in order until one succeeds
+func (c *Callback) SetCallbackUrls(urls ...string) {
+	c.CallbackUrl = strings.Join(urls, ";")
+}
+
+func (c *Callback) GetCallbackUrls() []string {
+	if c.CallbackUrl == "" {
+		return nil
+	}
+	return strings.Split(c.CallbackUrl, ";")
+}
+
 func (c *Callback) Validate() error {
 	if c.CallbackUrl == "" {
-		return fmt.Errorf("missing required CallbackUrl")
+		return configErrorf("CallbackUrl", "missing required CallbackUrl")
 	}
 	if c.CallbackBody == "" {
-		return fmt.Errorf("missing required CallbackBody")
+		return configErrorf("CallbackBody", "missing required CallbackBody")
+	}
+
+	urls := c.GetCallbackUrls()
+	if len(urls) > MaxCallbackUrls {
+		return configErrorf("CallbackUrl", "too many CallbackUrl, at most %d", MaxCallbackUrls)
+	}
+	hasHttps := false
+	for _, callbackUrl := range urls {
+		u, err := url.Parse(callbackUrl)
+		if err != nil {
+			return configErrorf("CallbackUrl", "invalid CallbackUrl %q: %w", callbackUrl, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return configErrorf("CallbackUrl", "invalid CallbackUrl %q, scheme must be http or https", callbackUrl)
+		}
+		if err = validateCallbackHost(u.Host); err != nil {
+			return configErrorf("CallbackUrl", "invalid CallbackUrl %q: %w", callbackUrl, err)
+		}
+		if u.User != nil || u.Fragment != "" {
+			return configErrorf("CallbackUrl", "invalid CallbackUrl %q, userinfo and fragment are not allowed", callbackUrl)
+		}
+		hasHttps = hasHttps || u.Scheme == "https"
+	}
+
+	if c.CallbackHost != "" {
+		if err := validateCallbackHost(c.CallbackHost); err != nil {
+			return configErrorf("CallbackHost", "invalid CallbackHost: %w", err)
+		}
+	}
+	if c.CallbackSNI && !hasHttps {
+		return configErrorf("CallbackSNI", "CallbackSNI requires an https CallbackUrl")
+	}
+	if c.CallbackBodyType != "" && c.CallbackBodyType != CallbackBodyTypeParam && c.CallbackBodyType != CallbackBodyTypeForm {
+		return configErrorf("CallbackBodyType", "unsupported CallbackBodyType %q", c.CallbackBodyType)
+	}
+	return nil
+}
+
+// validateCallbackHost : host name or ip, with an optional port
+func validateCallbackHost(host string) error {
+	name, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		name, port = h, p
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+	if net.ParseIP(strings.Trim(name, "[]")) != nil {
+		return nil
+	}
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host %q", host)
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return InvalidHostError(string(c))
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,245 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const MaxTokenRequestBodySize = 64 << 10
+
+// Token response formats, the format query parameter or json field
+const TokenFormatToken = ""
+const TokenFormatFields = "fields"
+const TokenFormatUppy = "uppy"
+const TokenFormatPlupload = "plupload"
+
+// TokenRequest : the file the client is about to upload, from the query of a GET or the json body of a POST
+type TokenRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Format      string `json:"format"`
+}
+
+// HTTPError : an error with the status and code the handler responds with
+type HTTPError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// TokenHandler : issues a SignatureToken per request, works with any router as a plain http.Handler
+type TokenHandler struct {
+	Token *Token
+
+	// Authenticate : optional, the user of the request, used as KeyInput.User;
+	// an error rejects the request with 401, or with the status of an *HTTPError
+	Authenticate func(r *http.Request) (string, error)
+	// Customize : optional, adjust the token of the request, e.g. SetCallbackVars for the user
+	Customize func(r *http.Request, user string, token *Token) (*Token, error)
+	// AllowedOrigins : optional, CORS origins, "*" for any, empty: no CORS headers
+	AllowedOrigins []string
+}
+
+func NewTokenHandler(config *Config) *TokenHandler {
+	return &TokenHandler{Token: NewToken(config)}
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	h.setCORSHeaders(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		writeJSONError(w, &HTTPError{Status: http.StatusMethodNotAllowed, Code: "MethodNotAllowed", Message: "method " + r.Method + " not allowed"})
+		return
+	}
+
+	var user string
+	if h.Authenticate != nil {
+		var err error
+		if user, err = h.Authenticate(r); err != nil {
+			var httpError *HTTPError
+			if !errors.As(err, &httpError) {
+				httpError = &HTTPError{Status: http.StatusUnauthorized, Code: "Unauthorized", Message: err.Error()}
+			}
+			writeJSONError(w, httpError)
+			return
+		}
+	}
+
+	req, err := readTokenRequest(r)
+	if err != nil {
+		writeJSONError(w, &HTTPError{Status: http.StatusBadRequest, Code: "InvalidArgument", Message: err.Error()})
+		return
+	}
+	token, err := h.requestToken(req, user)
+	if err != nil {
+		writeJSONError(w, &HTTPError{Status: http.StatusBadRequest, Code: "InvalidArgument", Message: err.Error()})
+		return
+	}
+	if h.Customize != nil {
+		if token, err = h.Customize(r, user, token); err != nil {
+			writeJSONError(w, toHTTPError(err))
+			return
+		}
+	}
+
+	signatureToken, err := token.Generate()
+	if err != nil {
+		writeJSONError(w, toHTTPError(err))
+		return
+	}
+	var body any
+	switch req.Format {
+	case TokenFormatFields:
+		body = map[string]any{"url": signatureToken.Host, "fields": signatureToken.FormFields()}
+	case TokenFormatUppy:
+		body = signatureToken.UppyParams()
+	case TokenFormatPlupload:
+		body = signatureToken.PluploadParams()
+	default:
+		body = signatureToken
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// requestToken : the token with the key input and the size and content type conditions of the request
+func (h *TokenHandler) requestToken(req *TokenRequest, user string) (*Token, error) {
+	switch req.Format {
+	case TokenFormatToken, TokenFormatFields, TokenFormatUppy, TokenFormatPlupload:
+	default:
+		return nil, fmt.Errorf("unsupported format %q", req.Format)
+	}
+	config := h.Token.config
+	file := FileDescriptor{Name: req.Filename, Size: req.Size, ContentType: req.ContentType}
+	if err := config.validateFile(&file); err != nil {
+		return nil, err
+	}
+
+	var conditions []any
+	if file.Size > 0 {
+		conditions = append(conditions, []any{PolicyConditionContentLengthRange, file.Size, file.Size})
+	} else if config.MaxFileSize > 0 {
+		conditions = append(conditions, []any{PolicyConditionContentLengthRange, 0, config.MaxFileSize})
+	}
+	if file.ContentType != "" {
+		conditions = append(conditions, []any{PolicyConditionEq, "$content-type", file.ContentType})
+	}
+
+	token := h.Token
+	if len(conditions) > 0 {
+		policy := token.policy
+		if policy == nil {
+			policy = newPolicy(config)
+		}
+		token = token.SetPolicy(policy.withConditions(conditions...))
+	}
+	if file.Name != "" || user != "" {
+		var keyInput KeyInput
+		if token.keyInput != nil {
+			keyInput = *token.keyInput
+		}
+		if file.Name != "" {
+			keyInput.Filename = file.Name
+		}
+		if user != "" {
+			keyInput.User = user
+		}
+		token = token.SetKeyInput(&keyInput)
+	}
+	return token, nil
+}
+
+// readTokenRequest : query parameters of a GET, json body of a POST
+func readTokenRequest(r *http.Request) (*TokenRequest, error) {
+	req := new(TokenRequest)
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxTokenRequestBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > MaxTokenRequestBodySize {
+			return nil, fmt.Errorf("request body too large")
+		}
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, req); err != nil {
+				return nil, fmt.Errorf("invalid json body: %w", err)
+			}
+		}
+		return req, nil
+	}
+
+	query := r.URL.Query()
+	req.Filename = query.Get("filename")
+	req.ContentType = query.Get("content_type")
+	req.Format = query.Get("format")
+	if size := query.Get("size"); size != "" {
+		var err error
+		if req.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid size %q", size)
+		}
+	}
+	return req, nil
+}
+
+func (h *TokenHandler) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(h.AllowedOrigins) == 0 {
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	for _, allowed := range h.AllowedOrigins {
+		if allowed != "*" && !strings.EqualFold(allowed, origin) {
+			continue
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		return
+	}
+}
+
+// toHTTPError : an *HTTPError as is, a key the request or the user made invalid is a 400,
+// other errors such as credentials and config failures are internal and not exposed to the client
+func toHTTPError(err error) *HTTPError {
+	var httpError *HTTPError
+	if errors.As(err, &httpError) {
+		return httpError
+	}
+	if errors.Is(err, ErrInvalidKey) {
+		return &HTTPError{Status: http.StatusBadRequest, Code: "InvalidArgument", Message: err.Error()}
+	}
+	return &HTTPError{Status: http.StatusInternalServerError, Code: "InternalError", Message: "failed to issue token"}
+}
+
+func writeJSONError(w http.ResponseWriter, err *HTTPError) {
+	writeJSON(w, err.Status, map[string]*HTTPError{"error": err})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	bodyByte, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		bodyByte = []byte(`{"error":{"code":"InternalError","message":"failed to encode response"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(bodyByte)))
+	w.WriteHeader(status)
+	w.Write(bodyByte)
+}
@@ -0,0 +1,175 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTokenHandler() *TokenHandler {
+	handler := NewTokenHandler(&Config{
+		AccessKeyId:     "yourAccessKeyId",
+		AccessKeySecret: "yourAccessKeySecret",
+		Host:            "https://bucket-name.oss-cn-hangzhou.aliyuncs.com",
+		Directory:       "user-dir-prefix/",
+		KeyTemplate:     "${user}/${uuid}${ext}",
+		MaxFileSize:     1024,
+		ContentTypes:    []string{"image/*"},
+	})
+	handler.Token.now = func() time.Time {
+		return time.Date(2024, 12, 31, 23, 50, 0, 0, time.UTC)
+	}
+	handler.Authenticate = func(r *http.Request) (string, error) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			return "", errors.New("invalid bearer token")
+		}
+		return "user-1", nil
+	}
+	handler.AllowedOrigins = []string{"https://www.example.com"}
+	return handler
+}
+
+func serveTokenHandler(handler http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer user-token")
+	req.Header.Set("Origin", "https://www.example.com")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenHandler(t *testing.T) {
+	handler := newTestTokenHandler()
+	handler.Customize = func(r *http.Request, user string, token *Token) (*Token, error) {
+		return token.SetCallback(&Callback{CallbackUrl: "http://domain.com/oss/callback", CallbackBody: "object=${object}"}).
+			SetCallbackVars(map[string]string{"user_id": user}), nil
+	}
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"get":  serveTokenHandler(handler, "GET", "/oss/token?filename=image.jpg&content_type=image%2Fjpeg&size=512", ""),
+		"post": serveTokenHandler(handler, "POST", "/oss/token", `{"filename":"image.jpg","content_type":"image/jpeg","size":512}`),
+	} {
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", name, w.Code, w.Body)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Access-Control-Allow-Origin") != "https://www.example.com" {
+			t.Errorf("%s: headers error %v", name, w.Header())
+		}
+		var signatureToken SignatureToken
+		if err := json.Unmarshal(w.Body.Bytes(), &signatureToken); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(signatureToken.Key, "user-dir-prefix/user-1/") || !strings.HasSuffix(signatureToken.Key, ".jpg") || len(signatureToken.CallbackVars) == 0 {
+			t.Errorf("%s: token error %+v", name, signatureToken)
+		}
+		fields := map[string]string{"key": signatureToken.Key, "content-type": "image/jpeg"}
+		at := time.Date(2024, 12, 31, 23, 55, 0, 0, time.UTC)
+		if violations, err := EvaluatePolicy(signatureToken.Policy, fields, 512, at); err != nil || len(violations) != 0 {
+			t.Errorf("%s: unexpected violations %v %v", name, violations, err)
+		}
+		if violations, _ := EvaluatePolicy(signatureToken.Policy, fields, 513, at); len(violations) != 1 {
+			t.Errorf("%s: size violation error %v", name, violations)
+		}
+	}
+
+	w := serveTokenHandler(handler, "GET", "/oss/token?filename=image.jpg&content_type=image%2Fjpeg&format=uppy", "")
+	var uppy UppyParams
+	if err := json.Unmarshal(w.Body.Bytes(), &uppy); err != nil || uppy.Method != "POST" || uppy.Fields["key"] == "" {
+		t.Error("uppy format error", w.Body)
+	}
+}
+
+func TestTokenHandlerErrors(t *testing.T) {
+	handler := newTestTokenHandler()
+
+	for name, c := range map[string]struct {
+		method string
+		target string
+		body   string
+		status int
+		expect string
+	}{
+		"size":         {"GET", "/oss/token?filename=image.jpg&content_type=image%2Fjpeg&size=2048", "", 400, `{"error":{"code":"InvalidArgument","message":"file size 2048 exceeds 1024"}}`},
+		"content type": {"POST", "/oss/token", `{"filename":"page.html","content_type":"text/html"}`, 400, `{"error":{"code":"InvalidArgument","message":"file content type \"text/html\" not allowed"}}`},
+		"json":         {"POST", "/oss/token", `{"filename":`, 400, ""},
+		"format":       {"GET", "/oss/token?content_type=image%2Fjpeg&format=xml", "", 400, `{"error":{"code":"InvalidArgument","message":"unsupported format \"xml\""}}`},
+		"method":       {"DELETE", "/oss/token", "", 405, `{"error":{"code":"MethodNotAllowed","message":"method DELETE not allowed"}}`},
+	} {
+		w := serveTokenHandler(handler, c.method, c.target, c.body)
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, got %d", name, c.status, w.Code)
+		}
+		if c.expect != "" && w.Body.String() != c.expect {
+			t.Errorf("%s: expect %s, got %s", name, c.expect, w.Body)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Errorf("%s: headers error %v", name, w.Header())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/oss/token?content_type=image%2Fjpeg", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Body.String() != `{"error":{"code":"Unauthorized","message":"invalid bearer token"}}` {
+		t.Error("unauthorized error", w.Code, w.Body)
+	}
+
+	handler.Authenticate = func(r *http.Request) (string, error) {
+		return "", &HTTPError{Status: http.StatusForbidden, Code: "Forbidden", Message: "uploads disabled"}
+	}
+	if w = serveTokenHandler(handler, "GET", "/oss/token", ""); w.Code != http.StatusForbidden {
+		t.Error("forbidden error", w.Code)
+	}
+
+	// the key made from the user is invalid, not the server
+	handler.Authenticate = func(r *http.Request) (string, error) {
+		return "user/1", nil
+	}
+	w = serveTokenHandler(handler, "GET", "/oss/token?content_type=image%2Fjpeg", "")
+	if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":{"code":"InvalidArgument","message":"invalid key: invalid character '/' in user \"user/1\""}}` {
+		t.Error("invalid key error", w.Code, w.Body)
+	}
+
+	// credentials and config failures stay internal
+	handler = NewTokenHandler(&Config{Host: "https://static.example.com", SignatureVersion: SignatureVersionV4})
+	if w = serveTokenHandler(handler, "GET", "/oss/token", ""); w.Code != http.StatusInternalServerError {
+		t.Error("config error", w.Code, w.Body)
+	}
+
+	handler = newTestTokenHandler()
+	handler.Customize = func(r *http.Request, user string, token *Token) (*Token, error) {
+		return nil, errors.New("database is down")
+	}
+	w = serveTokenHandler(handler, "GET", "/oss/token?content_type=image%2Fjpeg", "")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "database") {
+		t.Error("internal error must not be exposed", w.Code, w.Body)
+	}
+}
+
+func TestTokenHandlerCORS(t *testing.T) {
+	handler := newTestTokenHandler()
+
+	req := httptest.NewRequest("OPTIONS", "/oss/token", nil)
+	req.Header.Set("Origin", "https://www.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://www.example.com" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, POST, OPTIONS" || w.Header().Get("Vary") != "Origin" {
+		t.Error("preflight error", w.Code, w.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("origin must not be allowed", w.Header())
+	}
+}